@@ -1,16 +1,30 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/Work-Fort/Discord/internal/archive"
 	"github.com/Work-Fort/Discord/internal/backup"
 	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/importer"
 	"github.com/Work-Fort/Discord/internal/invite"
+	"github.com/Work-Fort/Discord/internal/plan"
+	"github.com/Work-Fort/Discord/internal/render"
+	"github.com/Work-Fort/Discord/internal/restore"
 	"github.com/Work-Fort/Discord/internal/setup"
 	"github.com/Work-Fort/Discord/internal/sync"
 )
 
+// Exit codes for the plan command, following terraform's -detailed-exitcode
+const (
+	exitNoChanges      = 0
+	exitError          = 1
+	exitChangesPending = 2
+)
+
 func main() {
 	if len(os.Args) < 2 {
 		printUsage()
@@ -24,8 +38,16 @@ func main() {
 		runSetup()
 	case "sync":
 		runSync()
+	case "plan":
+		runPlan()
 	case "backup":
 		runBackup()
+	case "restore":
+		runRestore()
+	case "import":
+		runImport()
+	case "render":
+		runRender()
 	case "validate":
 		runValidate()
 	case "create-invite":
@@ -41,18 +63,47 @@ func printUsage() {
 	fmt.Println("WorkFort Discord Infrastructure")
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("  discord-bot <command>")
+	fmt.Println("  discord-bot <command> [flags]")
 	fmt.Println()
 	fmt.Println("Commands:")
 	fmt.Println("  setup          Initial Discord server setup from YAML configs")
 	fmt.Println("  sync           Sync config changes to Discord server")
+	fmt.Println("  plan           Preview sync changes without modifying the server")
 	fmt.Println("  backup         Export current Discord state to YAML")
+	fmt.Println("  restore        Rebuild the server from a backup directory")
+	fmt.Println("  import         Write config/ from the current Discord server")
+	fmt.Println("  render         Render the message archive as a static site")
 	fmt.Println("  validate       Validate YAML configuration files")
 	fmt.Println("  create-invite  Create or retrieve permanent server invite link")
 	fmt.Println()
+	fmt.Println("Flags (sync, plan):")
+	fmt.Println("  --prune <mode>  Override server.yaml pruning mode: off, report, delete")
+	fmt.Println()
+	fmt.Println("Restore usage:")
+	fmt.Println("  discord-bot restore [--dry-run] [--only <kinds>] [--prune <mode>] <backup-dir>")
+	fmt.Println("  --dry-run       Show the changes without making them")
+	fmt.Println("  --only <kinds>  Comma-separated kinds to restore: server, role, category,")
+	fmt.Println("                  channel, overwrite, order (default all)")
+	fmt.Println()
+	fmt.Println("Flags (backup):")
+	fmt.Println("  --messages      Also archive new messages to backups/messages/")
+	fmt.Println()
+	fmt.Println("Flags (import):")
+	fmt.Println("  --force         Overwrite existing config files")
+	fmt.Println()
+	fmt.Println("Flags (render):")
+	fmt.Println("  --format <fmt>     Page format: html, markdown (default html)")
+	fmt.Println("  --out <dir>        Directory to write the site to (default site)")
+	fmt.Println("  --channels <list>  Comma-separated channel names to render (default all)")
+	fmt.Println()
 	fmt.Println("Environment variables:")
 	fmt.Println("  DISCORD_BOT_TOKEN  Discord bot token (required)")
 	fmt.Println("  DISCORD_GUILD_ID   Discord server/guild ID (required)")
+	fmt.Println()
+	fmt.Println("Plan exit codes:")
+	fmt.Println("  0  No changes")
+	fmt.Println("  1  Error")
+	fmt.Println("  2  Changes pending")
 }
 
 func runSetup() {
@@ -70,6 +121,25 @@ func runSetup() {
 	fmt.Println("✓ Discord server setup complete")
 }
 
+// parseReconcileFlags applies command-line overrides shared by sync and plan
+func parseReconcileFlags(cfg *config.Config, command string) error {
+	// ContinueOnError so a bad flag exits 1, not flag's 2 ("changes pending")
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	prune := flags.String("prune", "", "pruning mode for unmanaged resources: off, report, delete")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	if *prune != "" {
+		if err := config.ValidatePruneMode(*prune); err != nil {
+			return err
+		}
+		cfg.Server.Pruning.Mode = *prune
+	}
+
+	return nil
+}
+
 func runSync() {
 	cfg, err := config.Load()
 	if err != nil {
@@ -77,6 +147,11 @@ func runSync() {
 		os.Exit(1)
 	}
 
+	if err := parseReconcileFlags(cfg, "sync"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	if err := sync.Run(cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "Error running sync: %v\n", err)
 		os.Exit(1)
@@ -85,14 +160,45 @@ func runSync() {
 	fmt.Println("✓ Discord server sync complete")
 }
 
+func runPlan() {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	if err := parseReconcileFlags(cfg, "plan"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	pending, err := plan.Run(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error running plan: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	if pending {
+		os.Exit(exitChangesPending)
+	}
+
+	os.Exit(exitNoChanges)
+}
+
 func runBackup() {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	messages := flags.Bool("messages", false, "also archive new messages")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 		os.Exit(1)
 	}
 
-	if err := backup.Run(cfg); err != nil {
+	if err := backup.Run(cfg, *messages); err != nil {
 		fmt.Fprintf(os.Stderr, "Error running backup: %v\n", err)
 		os.Exit(1)
 	}
@@ -100,6 +206,93 @@ func runBackup() {
 	fmt.Println("✓ Discord server backup complete")
 }
 
+func runRestore() {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "show the changes without making them")
+	only := flags.String("only", "", "comma-separated kinds to restore")
+	prune := flags.String("prune", "", "pruning mode for unmanaged resources: off, report, delete")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Error: restore takes one backup directory, e.g. backups/20250101-090000")
+		os.Exit(1)
+	}
+	backupDir := flags.Arg(0)
+
+	kinds, err := restore.ParseKinds(*only)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg, err := config.LoadDir(backupDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading backup: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *prune != "" {
+		if err := config.ValidatePruneMode(*prune); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Server.Pruning.Mode = *prune
+	}
+
+	if err := restore.Run(cfg, backupDir, kinds, *dryRun); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running restore: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !*dryRun {
+		fmt.Println("✓ Discord server restore complete")
+	}
+}
+
+func runImport() {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	force := flags.Bool("force", false, "overwrite existing config files")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
+	// There's no config to load yet, only the credentials
+	cfg, err := config.LoadEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := importer.Run(cfg, *force); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running import: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runRender() {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	format := flags.String("format", render.FormatHTML, "page format: html, markdown")
+	out := flags.String("out", "site", "directory to write the site to")
+	only := flags.String("channels", "", "comma-separated channel names to render")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
+	var channels []string
+	for _, name := range strings.Split(*only, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			channels = append(channels, name)
+		}
+	}
+
+	// Rendering reads only the archive, so needs no config or credentials
+	if err := render.Run(archive.Dir, *out, *format, channels); err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering archive: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 func runValidate() {
 	cfg, err := config.Load()
 	if err != nil {
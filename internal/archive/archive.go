@@ -0,0 +1,240 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Dir is where message history is archived. Unlike structure backups, it is
+// a single archive that each run extends.
+var Dir = filepath.Join("backups", "messages")
+
+const indexHeader = `# WorkFort Discord Message Archive
+# The archived channels and threads, with the newest message saved from each.
+# Written by backup --messages; do not edit by hand.
+
+`
+
+// Index describes the archived channels and threads, keyed by channel ID.
+// Messages are in <channel ID>.jsonl, oldest first.
+type Index struct {
+	GuildID  string              `yaml:"guild_id"`
+	Roles    map[string]string   `yaml:"roles,omitempty"` // role names by ID, for mentions
+	Channels map[string]*Channel `yaml:"channels,omitempty"`
+}
+
+// Channel is an archived channel or thread
+type Channel struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`                // as in channels.yaml, or "thread"
+	Category string `yaml:"category,omitempty"`  // for channels
+	ParentID string `yaml:"parent_id,omitempty"` // for threads, the channel they're in
+	Cursor   string `yaml:"cursor,omitempty"`    // ID of the newest archived message
+
+	// ThreadCursor is when the most recently archived of a channel's threads
+	// was archived, as of the last run. Older archived threads have nothing
+	// new, since posting in a thread unarchives it.
+	ThreadCursor time.Time `yaml:"thread_cursor,omitempty"`
+}
+
+// Message is one archived message, a line of a channel's JSONL file
+type Message struct {
+	ID          string       `json:"id"`
+	Author      User         `json:"author"`
+	Timestamp   time.Time    `json:"timestamp"`
+	Edited      *time.Time   `json:"edited,omitempty"`
+	Content     string       `json:"content"`
+	Embeds      []Embed      `json:"embeds,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	ReplyTo     string       `json:"reply_to,omitempty"`  // ID of the message replied to
+	ThreadID    string       `json:"thread_id,omitempty"` // thread started from this message
+	Mentions    []User       `json:"mentions,omitempty"`
+	Reactions   []Reaction   `json:"reactions,omitempty"`
+}
+
+// User is a message author or mentioned user
+type User struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bot         bool   `json:"bot,omitempty"`
+}
+
+// Name returns the name Discord shows for a user
+func (u User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Username
+}
+
+// Embed is the readable part of a message embed, such as a link preview or
+// a GitHub notification
+type Embed struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Image       string `json:"image,omitempty"`
+	ImageProxy  string `json:"image_proxy,omitempty"` // Discord's copy of Image
+	ImageFile   string `json:"image_file,omitempty"`  // saved copy of Image, in FilesDir
+}
+
+// Attachment is an attached file's metadata. Discord's URLs for attached
+// files expire, so images are saved too; other files aren't.
+type Attachment struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int    `json:"size"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	URL         string `json:"url"`
+	File        string `json:"file,omitempty"` // saved copy, in FilesDir
+}
+
+// FilesDir is the directory within an archive that holds saved images
+const FilesDir = "files"
+
+// FilePath is where a saved file is kept in the archive in dir
+func FilePath(dir, name string) string {
+	return filepath.Join(dir, FilesDir, name)
+}
+
+// IsImage reports whether an attachment is an image, which is saved with
+// the archive and shown inline
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// Reaction is one emoji reacted to a message and how many reacted with it
+type Reaction struct {
+	Emoji string `json:"emoji"` // the emoji, or a custom emoji's name
+	Count int    `json:"count"`
+}
+
+// NewIndex returns an empty index for a guild
+func NewIndex(guildID string) *Index {
+	return &Index{
+		GuildID:  guildID,
+		Roles:    make(map[string]string),
+		Channels: make(map[string]*Channel),
+	}
+}
+
+// LoadIndex reads the archive index in dir. A missing index yields an empty
+// one, since nothing has been archived yet. An empty guildID accepts an
+// archive of any guild, for reading it offline.
+func LoadIndex(dir, guildID string) (*Index, error) {
+	path := filepath.Join(dir, "index.yaml")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewIndex(guildID), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	index := NewIndex(guildID)
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	if guildID != "" && index.GuildID != guildID {
+		return nil, fmt.Errorf("%s belongs to guild %s, not %s", path, index.GuildID, guildID)
+	}
+
+	// Sections omitted from the file unmarshal as nil maps
+	if index.Roles == nil {
+		index.Roles = make(map[string]string)
+	}
+	if index.Channels == nil {
+		index.Channels = make(map[string]*Channel)
+	}
+
+	return index, nil
+}
+
+// Save writes the index to dir
+func (i *Index) Save(dir string) error {
+	data, err := yaml.Marshal(i)
+	if err != nil {
+		return fmt.Errorf("encoding archive index: %w", err)
+	}
+
+	path := filepath.Join(dir, "index.yaml")
+	if err := os.WriteFile(path, append([]byte(indexHeader), data...), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// messagesPath is the JSONL file of a channel's messages
+func messagesPath(dir, channelID string) string {
+	return filepath.Join(dir, channelID+".jsonl")
+}
+
+// Append adds messages, oldest first, to the end of a channel's file
+func Append(dir, channelID string, messages []Message) error {
+	path := messagesPath(dir, channelID)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, m := range messages {
+		if err := enc.Encode(m); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+// Messages reads a channel's archived messages, oldest first. A channel
+// with nothing archived has no messages.
+func Messages(dir, channelID string) ([]Message, error) {
+	path := messagesPath(dir, channelID)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(f)
+	// Messages are up to 4000 characters, plus embeds
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("parsing %s: line %d: %w", path, line, err)
+		}
+		messages = append(messages, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,106 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppend(t *testing.T) {
+	dir := t.TempDir()
+	first := []Message{
+		{ID: "1", Author: User{ID: "10", Username: "alice"}, Timestamp: time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC), Content: "<b>hi</b> & bye"},
+		{ID: "2", Author: User{ID: "11", Username: "bob"}, Content: "line one\nline two"},
+	}
+	second := []Message{{ID: "3", Author: User{ID: "10", Username: "alice"}, ReplyTo: "2"}}
+
+	if err := Append(dir, "200", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append(dir, "200", second); err != nil {
+		t.Fatal(err)
+	}
+
+	// One message per line, with HTML left readable
+	data, err := os.ReadFile(filepath.Join(dir, "200.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), data)
+	}
+	if !strings.Contains(lines[0], `"content":"<b>hi</b> & bye"`) {
+		t.Errorf("content is escaped: %s", lines[0])
+	}
+
+	messages, err := Messages(dir, "200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || messages[0].Content != first[0].Content || messages[1].Content != first[1].Content || messages[2].ReplyTo != "2" {
+		t.Errorf("read back %+v", messages)
+	}
+	if !messages[0].Timestamp.Equal(first[0].Timestamp) {
+		t.Errorf("timestamp %v, want %v", messages[0].Timestamp, first[0].Timestamp)
+	}
+}
+
+func TestMessagesMissing(t *testing.T) {
+	messages, err := Messages(t.TempDir(), "200")
+	if err != nil || messages != nil {
+		t.Errorf("got %v, %v for a channel with nothing archived", messages, err)
+	}
+}
+
+func TestMessagesCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "200.jsonl"), []byte("{\"id\":\"1\"}\n{\"id\":\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Messages(dir, "200"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("got error %v, want one naming line 2", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	// Nothing has been archived yet
+	index, err := LoadIndex(dir, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Channels) != 0 {
+		t.Errorf("new index has channels: %+v", index.Channels)
+	}
+
+	index.Roles["11"] = "Admin"
+	index.Channels["200"] = &Channel{Name: "general", Type: "text", Category: "COMMUNITY", Cursor: "3",
+		ThreadCursor: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	index.Channels["300"] = &Channel{Name: "help", Type: "thread", ParentID: "200"}
+	if err := index.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadIndex(dir, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Channels["200"]; got == nil || *got != *index.Channels["200"] {
+		t.Errorf("channel 200 is %+v, want %+v", got, index.Channels["200"])
+	}
+	if got := loaded.Channels["300"]; got == nil || got.Cursor != "" || got.ParentID != "200" {
+		t.Errorf("thread 300 is %+v", got)
+	}
+	if loaded.Roles["11"] != "Admin" {
+		t.Errorf("roles %v", loaded.Roles)
+	}
+
+	if _, err := LoadIndex(dir, "2"); err == nil || !strings.Contains(err.Error(), "guild 1") {
+		t.Errorf("got error %v, want one naming the other guild", err)
+	}
+}
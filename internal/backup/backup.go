@@ -4,15 +4,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/permissions"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/Work-Fort/Discord/internal/snowflake"
+	"github.com/Work-Fort/Discord/internal/state"
 	"github.com/bwmarrin/discordgo"
-	"gopkg.in/yaml.v3"
 )
 
-// Run exports current Discord server state to YAML files
-func Run(cfg *config.Config) error {
+// Run exports current Discord server state to YAML files and, if messages
+// is set, adds new messages to the message archive
+func Run(cfg *config.Config, messages bool) error {
 	session, err := discordgo.New("Bot " + cfg.BotToken)
 	if err != nil {
 		return fmt.Errorf("creating Discord session: %w", err)
@@ -33,14 +39,31 @@ func Run(cfg *config.Config) error {
 		return fmt.Errorf("creating backup directory: %w", err)
 	}
 
-	// Export roles
-	if err := exportRoles(session, cfg.GuildID, backupDir); err != nil {
-		return fmt.Errorf("exporting roles: %w", err)
+	tracked, err := state.Load(state.Path, cfg.GuildID)
+	if err != nil {
+		return fmt.Errorf("loading state: %w", err)
 	}
 
-	// Export channels
-	if err := exportChannels(session, cfg.GuildID, backupDir); err != nil {
-		return fmt.Errorf("exporting channels: %w", err)
+	live, err := reconcile.Fetch(session, cfg.GuildID)
+	if err != nil {
+		return fmt.Errorf("reading server state: %w", err)
+	}
+
+	snapshot, err := Export(live, tracked, cfg.Server.Pruning, backupDir)
+	if err != nil {
+		return err
+	}
+
+	if err := snapshot.Save(filepath.Join(backupDir, "state.yaml")); err != nil {
+		return fmt.Errorf("exporting state: %w", err)
+	}
+
+	reportMissing(tracked, snapshot)
+
+	if messages {
+		if err := archiveMessages(session, live); err != nil {
+			return fmt.Errorf("archiving messages: %w", err)
+		}
 	}
 
 	fmt.Printf("✓ Backup saved to: %s\n", backupDir)
@@ -48,39 +71,122 @@ func Run(cfg *config.Config) error {
 	return nil
 }
 
-func exportRoles(session *discordgo.Session, guildID, backupDir string) error {
-	roles, err := session.GuildRoles(guildID)
-	if err != nil {
-		return fmt.Errorf("fetching roles: %w", err)
+// Export writes the server settings, roles, and channels of a live guild
+// to dir in the config file format, and returns the IDs of everything it
+// exported, keyed by the names written. Resources tracked under a key keep
+// it. Pruning isn't a Discord setting, so it is given.
+func Export(live *reconcile.Live, tracked *state.State, pruning config.PruningConfig, dir string) (*state.State, error) {
+	snapshot := state.New(live.GuildID)
+
+	// Export roles
+	if err := exportRoles(live, tracked, snapshot, dir); err != nil {
+		return nil, fmt.Errorf("exporting roles: %w", err)
+	}
+
+	// Export channels
+	if err := exportChannels(live, tracked, snapshot, dir); err != nil {
+		return nil, fmt.Errorf("exporting channels: %w", err)
+	}
+
+	// Export server settings, which refer to the exported channels
+	if err := exportServer(live, pruning, snapshot, dir); err != nil {
+		return nil, fmt.Errorf("exporting server settings: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// reportMissing warns about resources tracked in the committed state file
+// whose IDs no longer exist in the guild, e.g. deleted by hand in Discord
+func reportMissing(tracked, snapshot *state.State) {
+	exported := snapshot.IDs()
+
+	sections := []struct {
+		kind string
+		ids  map[string]string
+	}{
+		{"role", tracked.Roles},
+		{"category", tracked.Categories},
+		{"channel", tracked.Channels},
+		{"forum tag", tracked.Tags},
+	}
+
+	for _, section := range sections {
+		for _, name := range sortedKeys(section.ids) {
+			if !exported[section.ids[name]] {
+				fmt.Printf("  ⚠ Tracked %s no longer exists: %s (%s)\n", section.kind, name, section.ids[name])
+			}
+		}
+	}
+}
+
+// exportSettings copies the message, thread, and voice settings that apply
+// to a channel's type
+func exportSettings(ch *discordgo.Channel, extra reconcile.ChannelExtra, channel *config.Channel) {
+	if config.HasSlowmode(channel.Type) {
+		channel.Slowmode = ch.RateLimitPerUser
+	}
+	channel.NSFW = ch.NSFW
+
+	if config.HasThreads(channel.Type) {
+		channel.DefaultAutoArchive = extra.DefaultAutoArchiveDuration
+	}
+	if config.HasThreadSlowmode(channel.Type) {
+		channel.DefaultThreadSlowmode = ch.DefaultThreadRateLimitPerUser
 	}
 
+	if config.IsVoice(channel.Type) {
+		channel.Bitrate = ch.Bitrate
+		channel.UserLimit = ch.UserLimit
+		if extra.RTCRegion != nil {
+			channel.RTCRegion = *extra.RTCRegion
+		}
+		if extra.VideoQualityMode != 0 {
+			channel.VideoQuality = config.SettingName(config.VideoQualityModes, extra.VideoQualityMode)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// exportRoles writes the roles highest first, as roles.yaml lists them.
+// @everyone and roles managed by bots or integrations aren't configurable,
+// so they're left out.
+func exportRoles(live *reconcile.Live, tracked, snapshot *state.State, backupDir string) error {
+	roles := configRoles(live)
+	keys := trackedKeys(tracked.Roles)
 	rolesConfig := config.RolesConfig{
-		Roles: make([]config.Role, 0),
+		Roles: make([]config.Role, 0, len(roles)),
 	}
 
 	for _, role := range roles {
-		// Skip @everyone role
-		if role.Name == "@everyone" {
-			continue
+		names, unknown := permissions.Names(role.Permissions)
+		if unknown != 0 {
+			fmt.Printf("  ⚠ Role %s has permission bits with no known name, left out of the backup: 0x%x\n", role.Name, unknown)
 		}
 
-		rolesConfig.Roles = append(rolesConfig.Roles, config.Role{
+		exported := config.Role{
 			Name:        role.Name,
+			Key:         keyFor(keys, role.ID, role.Name),
 			Color:       fmt.Sprintf("#%06x", role.Color),
-			Permissions: []string{}, // TODO: Convert int64 permissions back to names
+			Permissions: append(config.PermissionList{}, names...),
 			Hoist:       role.Hoist,
 			Mentionable: role.Mentionable,
-		})
-	}
+		}
 
-	data, err := yaml.Marshal(rolesConfig)
-	if err != nil {
-		return fmt.Errorf("marshaling roles: %w", err)
+		snapshot.Roles[exported.StateKey()] = role.ID
+		rolesConfig.Roles = append(rolesConfig.Roles, exported)
 	}
 
-	path := filepath.Join(backupDir, "roles.yaml")
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return fmt.Errorf("writing roles backup: %w", err)
+	if err := config.Write(filepath.Join(backupDir, "roles.yaml"), config.RolesFile, rolesConfig); err != nil {
+		return err
 	}
 
 	fmt.Printf("  ✓ Exported roles (%d)\n", len(rolesConfig.Roles))
@@ -88,65 +194,183 @@ func exportRoles(session *discordgo.Session, guildID, backupDir string) error {
 	return nil
 }
 
-func exportChannels(session *discordgo.Session, guildID, backupDir string) error {
-	channels, err := session.GuildChannels(guildID)
-	if err != nil {
-		return fmt.Errorf("fetching channels: %w", err)
+// configRoles returns the roles a config can manage, highest first
+func configRoles(live *reconcile.Live) []*discordgo.Role {
+	roles := make([]*discordgo.Role, 0, len(live.Roles))
+	for _, role := range live.Roles {
+		if role.ID != live.GuildID && !role.Managed {
+			roles = append(roles, role)
+		}
 	}
+	sort.SliceStable(roles, func(i, j int) bool {
+		if roles[i].Position != roles[j].Position {
+			return roles[i].Position > roles[j].Position
+		}
+		return snowflake.Less(roles[i].ID, roles[j].ID)
+	})
+
+	return roles
+}
+
+// exportChannels writes the channels in sidebar order. Positions are
+// renumbered from 1 within each category, as channels.yaml numbers them,
+// since Discord's raw positions have gaps.
+func exportChannels(live *reconcile.Live, tracked, snapshot *state.State, backupDir string) error {
+	categoryKeys := trackedKeys(tracked.Categories)
+	channelKeys := trackedKeys(tracked.Channels)
+	tagKeys := trackedKeys(tracked.Tags)
+	targets := overwriteTargets(live)
 
 	channelsConfig := config.ChannelsConfig{
 		Categories: make([]config.Category, 0),
 	}
 
-	// Build category map
-	categoryMap := make(map[string]*config.Category)
+	// Index of each category in channelsConfig.Categories, by ID
+	categoryIndex := make(map[string]int)
 
-	for _, ch := range channels {
-		if ch.Type == discordgo.ChannelTypeGuildCategory {
-			category := config.Category{
-				Name:     ch.Name,
-				Position: ch.Position,
-				Channels: make([]config.Channel, 0),
-			}
-			categoryMap[ch.ID] = &category
-			channelsConfig.Categories = append(channelsConfig.Categories, category)
+	var categories, channels []*discordgo.Channel
+	for _, ch := range live.Channels {
+		switch {
+		case ch.Type == discordgo.ChannelTypeGuildCategory:
+			categories = append(categories, ch)
+		case !ch.IsThread():
+			channels = append(channels, ch)
 		}
 	}
+	sortByPosition(categories)
+	sortByPosition(channels)
 
-	// Add channels to categories
-	for _, ch := range channels {
-		if ch.ParentID != "" {
-			if category, ok := categoryMap[ch.ParentID]; ok {
-				channelType := "text"
-				if ch.Type == discordgo.ChannelTypeGuildVoice {
-					channelType = "voice"
-				} else if ch.Type == discordgo.ChannelTypeGuildForum {
-					channelType = "forum"
-				}
-
-				channel := config.Channel{
-					Name:     ch.Name,
-					Type:     channelType,
-					Topic:    ch.Topic,
-					Position: ch.Position,
-				}
-
-				category.Channels = append(category.Channels, channel)
-			}
+	for _, ch := range categories {
+		category := config.Category{
+			Name:        ch.Name,
+			Key:         keyFor(categoryKeys, ch.ID, ch.Name),
+			Position:    len(channelsConfig.Categories) + 1,
+			Permissions: exportOverwrites(targets, ch.Name, ch.PermissionOverwrites),
+			Channels:    make([]config.Channel, 0),
 		}
+
+		snapshot.Categories[category.StateKey()] = ch.ID
+		categoryIndex[ch.ID] = len(channelsConfig.Categories)
+		channelsConfig.Categories = append(channelsConfig.Categories, category)
 	}
 
-	data, err := yaml.Marshal(channelsConfig)
-	if err != nil {
-		return fmt.Errorf("marshaling channels: %w", err)
+	// Add channels to categories, or to the top level if they have none
+	for _, ch := range channels {
+		channelType, ok := config.ChannelTypeName(ch.Type)
+		if !ok {
+			fmt.Printf("  ⚠ Skipping channel with unsupported type %d: %s\n", ch.Type, ch.Name)
+			continue
+		}
+
+		var siblings *[]config.Channel
+		var parent *discordgo.Channel
+		if ch.ParentID == "" {
+			siblings = &channelsConfig.Channels
+		} else if i, ok := categoryIndex[ch.ParentID]; ok {
+			siblings = &channelsConfig.Categories[i].Channels
+			parent = categories[i]
+		} else {
+			continue
+		}
+
+		channel := config.Channel{
+			Name:     ch.Name,
+			Key:      keyFor(channelKeys, ch.ID, ch.Name),
+			Type:     channelType,
+			Position: len(*siblings) + 1,
+		}
+		if config.HasTopic(channelType) {
+			channel.Topic = ch.Topic
+		}
+		if parent == nil {
+			channel.Permissions = exportOverwrites(targets, ch.Name, ch.PermissionOverwrites)
+		} else {
+			channel.Permissions = channelOverwrites(targets, ch, parent)
+		}
+		exportSettings(ch, live.Extras[ch.ID], &channel)
+		if config.HasTags(channelType) {
+			exportForum(ch, &channel)
+			exportTags(ch, &channel, tagKeys, snapshot)
+		}
+
+		snapshot.Channels[channel.StateKey()] = ch.ID
+		*siblings = append(*siblings, channel)
 	}
 
-	path := filepath.Join(backupDir, "channels.yaml")
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return fmt.Errorf("writing channels backup: %w", err)
+	if err := config.Write(filepath.Join(backupDir, "channels.yaml"), config.ChannelsFile, channelsConfig); err != nil {
+		return err
 	}
 
-	fmt.Printf("  ✓ Exported channels (%d categories)\n", len(channelsConfig.Categories))
+	fmt.Printf("  ✓ Exported channels (%d categories, %d uncategorized)\n", len(channelsConfig.Categories), len(channelsConfig.Channels))
 
 	return nil
 }
+
+// exportForum copies a forum or media channel's post settings. Settings
+// Discord reports as unset are left out, as in a hand-written config.
+func exportForum(ch *discordgo.Channel, channel *config.Channel) {
+	if reaction := ch.DefaultReactionEmoji; reaction.EmojiName != "" {
+		channel.DefaultReaction = reaction.EmojiName
+	} else if reaction.EmojiID != "" {
+		channel.DefaultReaction = reaction.EmojiID
+	}
+
+	if ch.DefaultSortOrder != nil {
+		channel.SortOrder = config.SettingName(config.ForumSortOrders, int(*ch.DefaultSortOrder))
+	}
+	if channel.Type == "forum" && ch.DefaultForumLayout != discordgo.ForumLayoutNotSet {
+		channel.Layout = config.SettingName(config.ForumLayouts, int(ch.DefaultForumLayout))
+	}
+
+	channel.RequireTag = ch.Flags&discordgo.ChannelFlagRequireTag != 0
+}
+
+// exportTags copies a forum or media channel's tags in Discord's order,
+// recording their IDs in the snapshot
+func exportTags(ch *discordgo.Channel, channel *config.Channel, keys map[string]string, snapshot *state.State) {
+	prefix := channel.StateKey() + "/"
+	for _, live := range ch.AvailableTags {
+		tag := config.ForumTag{
+			Name:      live.Name,
+			Emoji:     live.EmojiName,
+			Moderated: live.Moderated,
+		}
+		if tag.Emoji == "" {
+			tag.Emoji = live.EmojiID
+		}
+		if key, ok := strings.CutPrefix(keys[live.ID], prefix); ok && key != live.Name {
+			tag.Key = key
+		}
+
+		snapshot.Tags[prefix+tag.StateKey()] = live.ID
+		channel.Tags = append(channel.Tags, tag)
+	}
+}
+
+// trackedKeys inverts a state section, mapping Discord IDs to config keys
+func trackedKeys(section map[string]string) map[string]string {
+	keys := make(map[string]string, len(section))
+	for key, id := range section {
+		keys[id] = key
+	}
+	return keys
+}
+
+// keyFor returns the config key tracked for a resource, or "" if it is
+// tracked by its current name or not at all
+func keyFor(keys map[string]string, id, name string) string {
+	if key := keys[id]; key != name {
+		return key
+	}
+	return ""
+}
+
+// sortByPosition orders channels as the sidebar shows them
+func sortByPosition(chs []*discordgo.Channel) {
+	sort.SliceStable(chs, func(i, j int) bool {
+		if chs[i].Position != chs[j].Position {
+			return chs[i].Position < chs[j].Position
+		}
+		return snowflake.Less(chs[i].ID, chs[j].ID)
+	})
+}
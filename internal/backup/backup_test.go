@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/Work-Fort/Discord/internal/state"
+	"github.com/bwmarrin/discordgo"
+)
+
+const guildID = "1"
+
+// testLive returns a guild using most of what a backup exports, all of it
+// expressible in config
+func testLive() *reconcile.Live {
+	sortOrder := discordgo.ForumSortOrderCreationDate
+	hidden := &discordgo.PermissionOverwrite{ID: guildID, Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel}
+	admins := &discordgo.PermissionOverwrite{ID: "21", Type: discordgo.PermissionOverwriteTypeRole, Allow: discordgo.PermissionViewChannel}
+	bot := &discordgo.PermissionOverwrite{ID: "22", Type: discordgo.PermissionOverwriteTypeRole, Allow: discordgo.PermissionViewChannel}
+
+	return &reconcile.Live{
+		GuildID: guildID,
+		Guild: &discordgo.Guild{
+			Name:                        "Test",
+			Description:                 "A test server",
+			VerificationLevel:           discordgo.VerificationLevelLow,
+			DefaultMessageNotifications: discordgo.MessageNotificationsOnlyMentions,
+			ExplicitContentFilter:       discordgo.ExplicitContentFilterAllMembers,
+			Features:                    []discordgo.GuildFeature{discordgo.GuildFeatureCommunity},
+			SystemChannelID:             "202",
+			RulesChannelID:              "201",
+			PublicUpdatesChannelID:      "204",
+			SystemChannelFlags:          discordgo.SystemChannelFlagsSuppressGuildReminderNotifications,
+		},
+		Roles: []*discordgo.Role{
+			{ID: guildID, Name: "@everyone"},
+			{ID: "20", Name: "Early Adopter", Position: 1, Color: 0x9b59b6, Hoist: true, Mentionable: true},
+			{ID: "23", Name: "Mod", Position: 2, Permissions: discordgo.PermissionManageMessages},
+			{ID: "21", Name: "Admin", Position: 3, Color: 0xe74c3c, Hoist: true, Permissions: discordgo.PermissionAdministrator | discordgo.PermissionViewChannel},
+			{ID: "22", Name: "Bot", Position: 4, Managed: true},
+		},
+		Channels: []*discordgo.Channel{
+			{ID: "204", Name: "start", Type: discordgo.ChannelTypeGuildText},
+			{ID: "101", Name: "WELCOME", Type: discordgo.ChannelTypeGuildCategory, Position: 1},
+			{ID: "202", Name: "welcome", ParentID: "101", Type: discordgo.ChannelTypeGuildText},
+			{ID: "100", Name: "COMMUNITY", Type: discordgo.ChannelTypeGuildCategory, Position: 2,
+				PermissionOverwrites: []*discordgo.PermissionOverwrite{hidden, admins, bot}},
+			{ID: "200", Name: "general", ParentID: "100", Type: discordgo.ChannelTypeGuildText, Position: 1, Topic: "Chat", RateLimitPerUser: 5,
+				PermissionOverwrites: []*discordgo.PermissionOverwrite{
+					hidden, admins, bot,
+					{ID: "23", Type: discordgo.PermissionOverwriteTypeRole}, // added without changing anything
+				}},
+			{ID: "201", Name: "rules", ParentID: "100", Type: discordgo.ChannelTypeGuildText, Position: 2,
+				PermissionOverwrites: []*discordgo.PermissionOverwrite{
+					hidden,
+					{ID: "21", Type: discordgo.PermissionOverwriteTypeRole, Allow: discordgo.PermissionViewChannel, Deny: discordgo.PermissionSendMessages},
+					{ID: "999", Type: discordgo.PermissionOverwriteTypeMember, Deny: discordgo.PermissionViewChannel},
+				}},
+			{ID: "203", Name: "dev", ParentID: "100", Type: discordgo.ChannelTypeGuildForum, Position: 4, Topic: "Tag your posts",
+				DefaultSortOrder: &sortOrder, DefaultForumLayout: discordgo.ForumLayoutListView,
+				DefaultReactionEmoji: discordgo.ForumDefaultReaction{EmojiName: "👍"}, Flags: discordgo.ChannelFlagRequireTag,
+				AvailableTags: []discordgo.ForumTag{
+					{ID: "300", Name: "bug", EmojiName: "🐛"},
+					{ID: "301", Name: "announcement", Moderated: true},
+				},
+				PermissionOverwrites: []*discordgo.PermissionOverwrite{hidden, admins, bot}},
+			{ID: "206", Name: "Voice", ParentID: "100", Type: discordgo.ChannelTypeGuildVoice, Position: 5, Bitrate: 96000, UserLimit: 10,
+				PermissionOverwrites: []*discordgo.PermissionOverwrite{hidden, admins, bot}},
+		},
+		Extras:     map[string]reconcile.ChannelExtra{},
+		BotRoleIDs: []string{"22"},
+	}
+}
+
+// TestRoundTrip checks that a backup restores the guild it was taken from:
+// loaded as config, it plans no changes against that guild, whether the
+// backup's state file is used or resources are matched by name
+func TestRoundTrip(t *testing.T) {
+	t.Setenv("DISCORD_BOT_TOKEN", "token")
+	t.Setenv("DISCORD_GUILD_ID", guildID)
+
+	live := testLive()
+	tracked := state.New(guildID)
+	tracked.Channels["rules-and-info"] = "201"
+	tracked.Tags["dev/issue"] = "300"
+
+	dir := t.TempDir()
+	snapshot, err := Export(live, tracked, config.PruningConfig{Mode: config.PruneReport}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Channels["rules-and-info"] != "201" || snapshot.Tags["dev/issue"] != "300" {
+		t.Errorf("backup doesn't keep tracked keys: %+v", snapshot)
+	}
+	if err := snapshot.Save(filepath.Join(dir, "state.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := config.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := state.Load(filepath.Join(dir, "state.yaml"), guildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs := []struct {
+		name string
+		st   *state.State
+	}{
+		{"with state", saved},
+		{"by name", state.New(guildID)},
+	}
+	for _, run := range runs {
+		p, err := reconcile.Build(restored, live, run.st)
+		if err != nil {
+			t.Fatalf("%s: %v", run.name, err)
+		}
+		if len(p.Changes) > 0 || len(p.Prune) > 0 {
+			var out strings.Builder
+			p.Print(&out)
+			t.Errorf("%s: plan after a backup round trip isn't empty:\n%s", run.name, out.String())
+		}
+	}
+}
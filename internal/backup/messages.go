@@ -0,0 +1,423 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Work-Fort/Discord/internal/archive"
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/Work-Fort/Discord/internal/snowflake"
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxMessages is the most messages Discord returns per request
+const maxMessages = 100
+
+// archiveMessages appends every message posted since the last run to the
+// message archive: messages in text and announcement channels, and in the
+// public threads of any channel that has them, archived or not. Each
+// channel's cursor is saved as soon as its messages are written, so an
+// interrupted run picks up where it stopped.
+func archiveMessages(session *discordgo.Session, live *reconcile.Live) error {
+	if err := os.MkdirAll(filepath.Join(archive.Dir, archive.FilesDir), 0755); err != nil {
+		return fmt.Errorf("creating archive directory: %w", err)
+	}
+
+	index, err := archive.LoadIndex(archive.Dir, live.GuildID)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range live.Roles {
+		index.Roles[role.ID] = role.Name
+	}
+
+	categories := make(map[string]string)
+	for _, ch := range live.Channels {
+		if ch.Type == discordgo.ChannelTypeGuildCategory {
+			categories[ch.ID] = ch.Name
+		}
+	}
+
+	var channels []*discordgo.Channel
+	for _, ch := range live.Channels {
+		channelType, ok := config.ChannelTypeName(ch.Type)
+		if !ok || !config.HasThreads(channelType) {
+			continue
+		}
+		entry := &archive.Channel{
+			Name:     ch.Name,
+			Type:     channelType,
+			Category: categories[ch.ParentID],
+		}
+		if previous, ok := index.Channels[ch.ID]; ok {
+			entry.Cursor, entry.ThreadCursor = previous.Cursor, previous.ThreadCursor
+		}
+		index.Channels[ch.ID] = entry
+		channels = append(channels, ch)
+	}
+	sortByPosition(channels)
+
+	threads, threadCursors, err := fetchThreads(session, index, live.GuildID, channels)
+	if err != nil {
+		return err
+	}
+	for _, thread := range threads {
+		index.Channels[thread.ID] = &archive.Channel{
+			Name:     thread.Name,
+			Type:     "thread",
+			ParentID: thread.ParentID,
+			Cursor:   cursor(index, thread.ID),
+		}
+	}
+
+	total := 0
+	for _, ch := range append(channels, threads...) {
+		// Forum and media channels hold only threads
+		if config.HasTags(index.Channels[ch.ID].Type) {
+			continue
+		}
+		if upToDate(index.Channels[ch.ID], ch) {
+			continue
+		}
+
+		count, err := archiveChannel(session, index, ch)
+		if forbidden(err) {
+			fmt.Printf("  ⚠ Can't read %s, skipped\n", ch.Name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("archiving %s: %w", ch.Name, err)
+		}
+		total += count
+	}
+
+	// Only now that their threads are archived, so an interrupted run lists
+	// them again
+	for id, threadCursor := range threadCursors {
+		index.Channels[id].ThreadCursor = threadCursor
+	}
+
+	if err := index.Save(archive.Dir); err != nil {
+		return err
+	}
+
+	fmt.Printf("  ✓ Archived messages (%d new, %d channels and threads)\n", total, len(index.Channels))
+
+	return nil
+}
+
+// cursor returns the ID of the newest archived message in a channel, or ""
+func cursor(index *archive.Index, channelID string) string {
+	if ch, ok := index.Channels[channelID]; ok {
+		return ch.Cursor
+	}
+	return ""
+}
+
+// upToDate reports whether a channel's newest message is already archived
+func upToDate(entry *archive.Channel, ch *discordgo.Channel) bool {
+	return entry.Cursor != "" && ch.LastMessageID != "" && !snowflake.Less(entry.Cursor, ch.LastMessageID)
+}
+
+// fetchThreads returns the public threads in channels that may have new
+// messages: every active thread, and the threads archived since each
+// channel's thread cursor, which Discord lists most recently archived
+// first. It also returns each channel's new thread cursor. Private threads
+// are left out, since the archive may be published.
+func fetchThreads(session *discordgo.Session, index *archive.Index, guildID string, channels []*discordgo.Channel) ([]*discordgo.Channel, map[string]time.Time, error) {
+	parents := make(map[string]bool)
+	for _, ch := range channels {
+		parents[ch.ID] = true
+	}
+
+	active, err := session.GuildThreadsActive(guildID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching active threads: %w", err)
+	}
+
+	var threads []*discordgo.Channel
+	for _, thread := range active.Threads {
+		if parents[thread.ParentID] && thread.Type != discordgo.ChannelTypeGuildPrivateThread {
+			threads = append(threads, thread)
+		}
+	}
+
+	threadCursors := make(map[string]time.Time)
+	for _, ch := range channels {
+		since := index.Channels[ch.ID].ThreadCursor
+		var before *time.Time
+	pages:
+		for {
+			archived, err := session.ThreadsArchived(ch.ID, before, maxMessages)
+			if forbidden(err) {
+				// Reported when its messages can't be read either
+				break
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("fetching archived threads of %s: %w", ch.Name, err)
+			}
+
+			for _, thread := range archived.Threads {
+				if thread.ThreadMetadata == nil {
+					break pages
+				}
+				archivedAt := thread.ThreadMetadata.ArchiveTimestamp
+				if archivedAt.Before(since) {
+					break pages
+				}
+				if archivedAt.After(threadCursors[ch.ID]) {
+					threadCursors[ch.ID] = archivedAt
+				}
+				threads = append(threads, thread)
+			}
+
+			if !archived.HasMore || len(archived.Threads) == 0 {
+				break
+			}
+			before = &archived.Threads[len(archived.Threads)-1].ThreadMetadata.ArchiveTimestamp
+		}
+	}
+
+	sort.SliceStable(threads, func(i, j int) bool { return snowflake.Less(threads[i].ID, threads[j].ID) })
+
+	return threads, threadCursors, nil
+}
+
+// archiveChannel pages forward from a channel's cursor, appending each page
+// and advancing the cursor. It returns how many messages were archived.
+func archiveChannel(session *discordgo.Session, index *archive.Index, ch *discordgo.Channel) (int, error) {
+	entry := index.Channels[ch.ID]
+	count := 0
+
+	for {
+		// "0" is before every message, so the first run starts at the oldest
+		after := entry.Cursor
+		if after == "" {
+			after = "0"
+		}
+
+		page, err := session.ChannelMessages(ch.ID, maxMessages, "", after, "")
+		if err != nil {
+			return count, err
+		}
+		if len(page) == 0 {
+			return count, nil
+		}
+
+		// Discord returns newest first
+		sort.Slice(page, func(i, j int) bool { return snowflake.Less(page[i].ID, page[j].ID) })
+
+		messages := make([]archive.Message, 0, len(page))
+		for _, m := range page {
+			message := archiveMessage(m)
+			saveImages(session.Client, &message)
+			messages = append(messages, message)
+		}
+		if err := archive.Append(archive.Dir, ch.ID, messages); err != nil {
+			return count, err
+		}
+
+		entry.Cursor = page[len(page)-1].ID
+		count += len(page)
+		if err := index.Save(archive.Dir); err != nil {
+			return count, err
+		}
+
+		if len(page) < maxMessages {
+			return count, nil
+		}
+	}
+}
+
+// archiveMessage converts a Discord message to its archived form
+func archiveMessage(m *discordgo.Message) archive.Message {
+	out := archive.Message{
+		ID:        m.ID,
+		Timestamp: m.Timestamp,
+		Edited:    m.EditedTimestamp,
+		Content:   m.Content,
+	}
+	if m.Author != nil {
+		out.Author = archiveUser(m.Author)
+	}
+	if m.MessageReference != nil && m.Type == discordgo.MessageTypeReply {
+		out.ReplyTo = m.MessageReference.MessageID
+	}
+	if m.Thread != nil {
+		out.ThreadID = m.Thread.ID
+	}
+
+	for _, user := range m.Mentions {
+		out.Mentions = append(out.Mentions, archiveUser(user))
+	}
+
+	for _, embed := range m.Embeds {
+		e := archive.Embed{
+			Title:       embed.Title,
+			Description: embed.Description,
+			URL:         embed.URL,
+		}
+		if embed.Author != nil {
+			e.Author = embed.Author.Name
+		}
+		if embed.Image != nil {
+			e.Image, e.ImageProxy = embed.Image.URL, embed.Image.ProxyURL
+		}
+		out.Embeds = append(out.Embeds, e)
+	}
+
+	for _, a := range m.Attachments {
+		out.Attachments = append(out.Attachments, archive.Attachment{
+			ID:          a.ID,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Width:       a.Width,
+			Height:      a.Height,
+			URL:         a.URL,
+		})
+	}
+
+	for _, r := range m.Reactions {
+		if r.Emoji == nil {
+			continue
+		}
+		out.Reactions = append(out.Reactions, archive.Reaction{Emoji: r.Emoji.Name, Count: r.Count})
+	}
+
+	return out
+}
+
+// saveImages downloads a message's images into the archive, since Discord's
+// URLs for attached files expire. Only Discord's own copies are downloaded:
+// attachments, and the copies Discord's media proxy keeps of embed images,
+// so a message can't make the backup fetch arbitrary URLs. An image that
+// can't be downloaded keeps only its URL, with a warning.
+func saveImages(client *http.Client, m *archive.Message) {
+	for i := range m.Attachments {
+		a := &m.Attachments[i]
+		if a.IsImage() && discordMedia(a.URL) {
+			a.File = saveFile(client, a.ID+"-"+unsafeChars.ReplaceAllString(a.Filename, "_"), a.URL)
+		}
+	}
+
+	for i := range m.Embeds {
+		e := &m.Embeds[i]
+		if e.Image != "" && discordMedia(e.ImageProxy) {
+			e.ImageFile = saveFile(client, fmt.Sprintf("%s-embed-%d%s", m.ID, i, imageExt(e.Image)), e.ImageProxy)
+		}
+	}
+}
+
+// discordMedia reports whether rawURL is on Discord's CDN or media proxy
+func discordMedia(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "https" && (u.Host == "cdn.discordapp.com" || u.Host == "media.discordapp.net")
+}
+
+// maxImageSize is the largest image saved, well above what Discord allows
+// to be uploaded without boosts
+const maxImageSize = 50 << 20
+
+// unsafeChars matches what's left out of saved file names
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// saveFile downloads rawURL into the archive as name, unless it was saved
+// before, and returns the name, or "" if it couldn't be downloaded
+func saveFile(client *http.Client, name, rawURL string) string {
+	dest := archive.FilePath(archive.Dir, name)
+	if _, err := os.Stat(dest); err == nil {
+		return name
+	}
+
+	if err := download(client, rawURL, dest); err != nil {
+		fmt.Printf("  ⚠ Couldn't save image %s, keeping only its URL: %v\n", name, err)
+		return ""
+	}
+
+	return name
+}
+
+// download writes the body of rawURL to dest, failing if it is larger than
+// maxImageSize. It writes to a temporary file first, so an interrupted
+// download isn't mistaken for a saved one.
+func download(client *http.Client, rawURL, dest string) error {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", rawURL, resp.Status)
+	}
+
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", tmp, err)
+	}
+	n, err := io.Copy(f, io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+	if n > maxImageSize {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("fetching %s: larger than %d MiB", rawURL, maxImageSize>>20)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+// imageExt returns the file extension of an image URL, or "" if it has
+// none that looks like one
+func imageExt(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	ext := path.Ext(u.Path)
+	if len(ext) > 5 || unsafeChars.MatchString(ext) {
+		return ""
+	}
+
+	return ext
+}
+
+// forbidden reports whether err is Discord refusing access, as for a
+// channel the bot can't view
+func forbidden(err error) bool {
+	var restErr *discordgo.RESTError
+	return errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusForbidden
+}
+
+func archiveUser(u *discordgo.User) archive.User {
+	return archive.User{
+		ID:          u.ID,
+		Username:    u.Username,
+		DisplayName: u.GlobalName,
+		Bot:         u.Bot,
+	}
+}
@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/Work-Fort/Discord/internal/archive"
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestArchiveMessage(t *testing.T) {
+	m := archiveMessage(&discordgo.Message{
+		ID:               "3",
+		Type:             discordgo.MessageTypeReply,
+		Author:           &discordgo.User{ID: "10", Username: "alice", GlobalName: "Alice"},
+		Content:          "see <#200>",
+		MessageReference: &discordgo.MessageReference{MessageID: "2"},
+		Thread:           &discordgo.Channel{ID: "300"},
+		Embeds:           []*discordgo.MessageEmbed{{Title: "PR #1", Image: &discordgo.MessageEmbedImage{URL: "https://example.com/a.png", ProxyURL: "https://media.discordapp.net/external/a.png"}}},
+		Attachments:      []*discordgo.MessageAttachment{{ID: "40", Filename: "shot.png", ContentType: "image/png", URL: "https://cdn.discordapp.com/shot.png"}},
+		Reactions: []*discordgo.MessageReactions{
+			{Emoji: &discordgo.Emoji{Name: "👍"}, Count: 2},
+			{Count: 1},
+		},
+	})
+
+	if m.ReplyTo != "2" || m.ThreadID != "300" || m.Author.Name() != "Alice" {
+		t.Errorf("got %+v", m)
+	}
+	if len(m.Embeds) != 1 || m.Embeds[0].Image != "https://example.com/a.png" || m.Embeds[0].ImageProxy != "https://media.discordapp.net/external/a.png" {
+		t.Errorf("embeds %+v", m.Embeds)
+	}
+	if len(m.Attachments) != 1 || !m.Attachments[0].IsImage() {
+		t.Errorf("attachments %+v", m.Attachments)
+	}
+	// A reaction without an emoji can't be shown, so it's left out
+	if len(m.Reactions) != 1 || m.Reactions[0].Emoji != "👍" || m.Reactions[0].Count != 2 {
+		t.Errorf("reactions %+v", m.Reactions)
+	}
+
+	// A forwarded or pinned message also has a reference, but isn't a reply
+	m = archiveMessage(&discordgo.Message{ID: "4", MessageReference: &discordgo.MessageReference{MessageID: "2"}})
+	if m.ReplyTo != "" {
+		t.Errorf("non-reply has reply_to %s", m.ReplyTo)
+	}
+}
+
+func TestUpToDate(t *testing.T) {
+	tests := []struct {
+		cursor, last string
+		want         bool
+	}{
+		{"", "5", false},
+		{"5", "5", true},
+		{"5", "12", false},
+		{"12", "5", true}, // the newest message was deleted
+		{"5", "", false},
+	}
+	for _, tt := range tests {
+		entry := &archive.Channel{Cursor: tt.cursor}
+		if got := upToDate(entry, &discordgo.Channel{LastMessageID: tt.last}); got != tt.want {
+			t.Errorf("cursor %q, last message %q: got %v, want %v", tt.cursor, tt.last, got, tt.want)
+		}
+	}
+}
+
+func TestDiscordMedia(t *testing.T) {
+	for url, want := range map[string]bool{
+		"https://cdn.discordapp.com/attachments/1/2/shot.png": true,
+		"https://media.discordapp.net/external/abc/a.png":     true,
+		"http://cdn.discordapp.com/attachments/1/2/shot.png":  false,
+		"https://cdn.discordapp.com.example.com/shot.png":     false,
+		"https://example.com/a.png":                           false,
+		"https://169.254.169.254/latest/meta-data":            false,
+		"": false,
+	} {
+		if got := discordMedia(url); got != want {
+			t.Errorf("%q: got %v, want %v", url, got, want)
+		}
+	}
+}
+
+func TestImageExt(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://cdn.discordapp.com/a/b/shot.png?ex=1": ".png",
+		"https://example.com/image":                    "",
+		"https://example.com/x.averylongext":           "",
+		"https://example.com/x.p%20g":                  "",
+	} {
+		if got := imageExt(url); got != want {
+			t.Errorf("%s: got %q, want %q", url, got, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/permissions"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/bwmarrin/discordgo"
+)
+
+// overwriteTargets maps the IDs of @everyone and the exported roles to the
+// names channels.yaml uses for them as overwrite targets
+func overwriteTargets(live *reconcile.Live) map[string]string {
+	targets := map[string]string{
+		// @everyone's role ID is the same as the guild ID
+		live.GuildID: config.EveryoneTarget,
+	}
+	for _, role := range configRoles(live) {
+		targets[role.ID] = role.Name
+	}
+
+	return targets
+}
+
+// exportOverwrites converts live overwrites to a config permissions block,
+// or nil if there are none. Overwrites for roles that aren't exported, such
+// as a bot's own role, are left out, since sync leaves them alone.
+func exportOverwrites(targets map[string]string, owner string, overwrites []*discordgo.PermissionOverwrite) map[string]config.PermissionSet {
+	var perms map[string]config.PermissionSet
+	for _, ow := range overwrites {
+		var target string
+		switch ow.Type {
+		case discordgo.PermissionOverwriteTypeMember:
+			target = config.MemberTargetPrefix + ow.ID
+		default:
+			name, ok := targets[ow.ID]
+			if !ok {
+				continue
+			}
+			target = name
+		}
+
+		set := make(config.PermissionSet)
+		addPermissions(set, owner, target, ow.Allow, true)
+		addPermissions(set, owner, target, ow.Deny, false)
+
+		if perms == nil {
+			perms = make(map[string]config.PermissionSet)
+		}
+		perms[target] = set
+	}
+
+	return perms
+}
+
+// addPermissions sets each permission in a bitfield to value, reporting any
+// bits that have no name
+func addPermissions(set config.PermissionSet, owner, target string, bits int64, value bool) {
+	names, unknown := permissions.Names(bits)
+	if unknown != 0 {
+		fmt.Printf("  ⚠ %s's overwrite for %s has permission bits with no known name, left out of the backup: 0x%x\n", owner, target, unknown)
+	}
+	for _, name := range names {
+		set[name] = value
+	}
+}
+
+// channelOverwrites exports the permissions of a channel in a category the
+// way channels.yaml expresses them: only what differs from the category,
+// since a channel's permissions are merged over its category's, and nil for
+// a channel synced with its category. An overwrite the category doesn't
+// have is kept even if it allows and denies nothing, as Discord creates
+// when a role is added to a channel without changing anything. Category
+// overwrites the channel lacks can't be expressed, so they're reported.
+func channelOverwrites(targets map[string]string, ch, category *discordgo.Channel) map[string]config.PermissionSet {
+	have := exportOverwrites(targets, ch.Name, ch.PermissionOverwrites)
+	inherited := exportOverwrites(targets, category.Name, category.PermissionOverwrites)
+
+	if sameOverwrites(have, inherited) {
+		return nil
+	}
+
+	var perms map[string]config.PermissionSet
+	for target, set := range have {
+		if _, ok := inherited[target]; !ok {
+			if perms == nil {
+				perms = make(map[string]config.PermissionSet)
+			}
+			perms[target] = set
+			continue
+		}
+		for name, value := range set {
+			if inheritedValue, ok := inherited[target][name]; ok && inheritedValue == value {
+				continue
+			}
+			if perms == nil {
+				perms = make(map[string]config.PermissionSet)
+			}
+			if perms[target] == nil {
+				perms[target] = make(config.PermissionSet)
+			}
+			perms[target][name] = value
+		}
+	}
+
+	var missing []string
+	for _, target := range sortedKeys(inherited) {
+		for _, name := range sortedKeys(inherited[target]) {
+			if _, ok := have[target][name]; !ok {
+				missing = append(missing, target+": "+name)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("  ⚠ %s lacks some of %s's overwrites, which config can't express; the backup gives it the category's (%s)\n", ch.Name, category.Name, strings.Join(missing, ", "))
+	}
+
+	return perms
+}
+
+// sameOverwrites reports whether two exported permissions blocks are equal
+func sameOverwrites(a, b map[string]config.PermissionSet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for target, set := range a {
+		other, ok := b[target]
+		if !ok || !maps.Equal(set, other) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,76 @@
+package backup
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/Work-Fort/Discord/internal/state"
+	"github.com/bwmarrin/discordgo"
+)
+
+// exportServer writes the server settings and special channels, naming
+// channels as channels.yaml does
+func exportServer(live *reconcile.Live, pruning config.PruningConfig, snapshot *state.State, backupDir string) error {
+	guild := live.Guild
+	channelKeys := trackedKeys(snapshot.Channels)
+
+	server := config.ServerConfig{
+		Name:        guild.Name,
+		Description: guild.Description,
+		Pruning:     pruning,
+	}
+	if server.Pruning.Protected == nil {
+		server.Pruning.Protected = []string{}
+	}
+
+	server.Settings.VerificationLevel = config.SettingName(config.VerificationLevels, int(guild.VerificationLevel))
+	server.Settings.DefaultNotificationLevel = config.SettingName(config.DefaultNotificationLevels, int(guild.DefaultMessageNotifications))
+	server.Settings.ExplicitContentFilter = config.SettingName(config.ExplicitContentFilters, int(guild.ExplicitContentFilter))
+
+	for _, feature := range guild.Features {
+		switch feature {
+		case discordgo.GuildFeatureCommunity:
+			server.Features.Community = true
+		case discordgo.GuildFeatureDiscoverable:
+			server.Features.Discoverable = true
+		}
+	}
+
+	special := []struct {
+		id  string
+		ref *string
+	}{
+		{guild.SystemChannelID, &server.Channels.System},
+		{guild.RulesChannelID, &server.Channels.Rules},
+		{guild.PublicUpdatesChannelID, &server.Channels.PublicUpdates},
+		{guild.AfkChannelID, &server.Channels.AFK},
+	}
+	for _, channel := range special {
+		if channel.id == "" {
+			continue
+		}
+		if key, ok := channelKeys[channel.id]; ok {
+			*channel.ref = key
+		} else {
+			fmt.Printf("  ⚠ Special channel %s wasn't exported, left out of server.yaml\n", channel.id)
+		}
+	}
+	if server.Channels.AFK != "" {
+		server.Channels.AFKTimeout = guild.AfkTimeout
+	}
+	flags, unknown := config.FlagNames(int(guild.SystemChannelFlags))
+	if unknown != 0 {
+		fmt.Printf("  ⚠ System channel flags with no known name, left out of the backup: 0x%x\n", unknown)
+	}
+	server.Channels.SystemChannelFlags = flags
+
+	if err := config.Write(filepath.Join(backupDir, "server.yaml"), config.ServerFile, server); err != nil {
+		return err
+	}
+
+	fmt.Println("  ✓ Exported server settings")
+
+	return nil
+}
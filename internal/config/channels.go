@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Channel type names used in channels.yaml, in the order they're listed in
+// errors
+var channelTypes = []struct {
+	name string
+	typ  discordgo.ChannelType
+}{
+	{"text", discordgo.ChannelTypeGuildText},
+	{"voice", discordgo.ChannelTypeGuildVoice},
+	{"forum", discordgo.ChannelTypeGuildForum},
+	{"announcement", discordgo.ChannelTypeGuildNews},
+	{"stage", discordgo.ChannelTypeGuildStageVoice},
+	{"media", discordgo.ChannelTypeGuildMedia},
+}
+
+// ChannelType returns the Discord channel type for a channels.yaml type name
+func ChannelType(name string) (discordgo.ChannelType, bool) {
+	for _, t := range channelTypes {
+		if t.name == name {
+			return t.typ, true
+		}
+	}
+
+	return 0, false
+}
+
+// ChannelTypeName returns the channels.yaml name for a Discord channel type,
+// or false if channels of that type can't be managed
+func ChannelTypeName(typ discordgo.ChannelType) (string, bool) {
+	for _, t := range channelTypes {
+		if t.typ == typ {
+			return t.name, true
+		}
+	}
+
+	return "", false
+}
+
+// HasTopic reports whether channels of a type have a topic. Forum and media
+// channels show it as their post guidelines.
+func HasTopic(typ string) bool {
+	return typ != "voice" && typ != "stage"
+}
+
+// HasTags reports whether channels of a type hold posts with tags
+func HasTags(typ string) bool {
+	return typ == "forum" || typ == "media"
+}
+
+// Video quality mode names, indexed by their Discord enum value. Discord
+// starts at 1; 0 means the channel has never had one set (auto).
+var VideoQualityModes = []string{"", "auto", "full"}
+
+// DefaultAutoArchiveDurations are the thread auto-archive durations Discord
+// accepts, in minutes
+var DefaultAutoArchiveDurations = []int{60, 1440, 4320, 10080}
+
+// Limits on channel settings
+const (
+	maxSlowmode     = 21600  // seconds
+	minBitrate      = 8000   // bits per second
+	maxBitrate      = 384000 // with boosts
+	maxVoiceUsers   = 99
+	maxStageMembers = 10000
+)
+
+// IsVoice reports whether channels of a type are voice or stage channels
+func IsVoice(typ string) bool {
+	return typ == "voice" || typ == "stage"
+}
+
+// HasSlowmode reports whether channels of a type support slowmode.
+// Announcement channels don't.
+func HasSlowmode(typ string) bool {
+	return typ != "announcement"
+}
+
+// HasThreads reports whether channels of a type hold threads (or posts)
+func HasThreads(typ string) bool {
+	return typ == "text" || typ == "announcement" || HasTags(typ)
+}
+
+// HasThreadSlowmode reports whether channels of a type have a default
+// slowmode for new threads
+func HasThreadSlowmode(typ string) bool {
+	return typ == "text" || HasTags(typ)
+}
+
+func validateChannelType(ch Channel) error {
+	if _, ok := ChannelType(ch.Type); ok {
+		return nil
+	}
+
+	var names []string
+	for _, t := range channelTypes {
+		names = append(names, t.name)
+	}
+
+	return fmt.Errorf("channel %q: unknown type %q (want one of: %s)", ch.Name, ch.Type, strings.Join(names, ", "))
+}
+
+// validateChannelSettings checks a channel's message, thread, and voice
+// settings against its type and Discord's limits
+func validateChannelSettings(ch Channel) error {
+	switch {
+	case ch.Slowmode != 0 && !HasSlowmode(ch.Type):
+		return fmt.Errorf("channel %q: %s channels don't support slowmode", ch.Name, ch.Type)
+	case ch.DefaultAutoArchive != 0 && !HasThreads(ch.Type):
+		return fmt.Errorf("channel %q: default_auto_archive only applies to channels with threads", ch.Name)
+	case ch.DefaultThreadSlowmode != 0 && !HasThreadSlowmode(ch.Type):
+		return fmt.Errorf("channel %q: default_thread_slowmode only applies to text, forum, and media channels", ch.Name)
+	case (ch.Bitrate != 0 || ch.UserLimit != 0 || ch.RTCRegion != "" || ch.VideoQuality != "") && !IsVoice(ch.Type):
+		return fmt.Errorf("channel %q: bitrate, user_limit, rtc_region, and video_quality only apply to voice and stage channels", ch.Name)
+	}
+
+	for _, s := range []struct {
+		name  string
+		value int
+	}{{"slowmode", ch.Slowmode}, {"default_thread_slowmode", ch.DefaultThreadSlowmode}} {
+		if s.value < 0 || s.value > maxSlowmode {
+			return fmt.Errorf("channel %q: %s must be between 0 and %d seconds", ch.Name, s.name, maxSlowmode)
+		}
+	}
+
+	if ch.DefaultAutoArchive != 0 {
+		valid := false
+		for _, minutes := range DefaultAutoArchiveDurations {
+			valid = valid || minutes == ch.DefaultAutoArchive
+		}
+		if !valid {
+			return fmt.Errorf("channel %q: invalid default_auto_archive %d (want one of: 60, 1440, 4320, 10080 minutes)", ch.Name, ch.DefaultAutoArchive)
+		}
+	}
+
+	if ch.Bitrate != 0 && (ch.Bitrate < minBitrate || ch.Bitrate > maxBitrate) {
+		return fmt.Errorf("channel %q: bitrate must be between %d and %d", ch.Name, minBitrate, maxBitrate)
+	}
+
+	maxUsers := maxVoiceUsers
+	if ch.Type == "stage" {
+		maxUsers = maxStageMembers
+	}
+	if ch.UserLimit < 0 || ch.UserLimit > maxUsers {
+		return fmt.Errorf("channel %q: user_limit must be between 0 (unlimited) and %d", ch.Name, maxUsers)
+	}
+
+	if ch.VideoQuality != "" && SettingValue(VideoQualityModes, ch.VideoQuality) < 1 {
+		return fmt.Errorf("channel %q: invalid video_quality %q (want auto or full)", ch.Name, ch.VideoQuality)
+	}
+
+	return nil
+}
@@ -10,14 +10,25 @@ import (
 
 // Config holds all Discord server configuration
 type Config struct {
-	Server       ServerConfig       `yaml:"-"`
-	Channels     ChannelsConfig     `yaml:"-"`
-	Roles        RolesConfig        `yaml:"-"`
-	Integrations IntegrationsConfig `yaml:"-"`
+	Server         ServerConfig         `yaml:"-"`
+	PermissionSets PermissionSetsConfig `yaml:"-"`
+	Channels       ChannelsConfig       `yaml:"-"`
+	Roles          RolesConfig          `yaml:"-"`
+	Integrations   IntegrationsConfig   `yaml:"-"`
 
 	// Runtime configuration
 	BotToken string `yaml:"-"`
 	GuildID  string `yaml:"-"`
+
+	// The files loaded, kept so validation can report the line of a bad
+	// entry
+	sources []source
+}
+
+// source is a loaded config file's YAML
+type source struct {
+	path string
+	doc  yaml.Node
 }
 
 // ServerConfig holds server settings
@@ -25,40 +36,97 @@ type ServerConfig struct {
 	Name        string `yaml:"name"`
 	Description string `yaml:"description"`
 	Settings    struct {
-		VerificationLevel         string `yaml:"verification_level"`
-		DefaultNotificationLevel  string `yaml:"default_notification_level"`
-		ExplicitContentFilter     string `yaml:"explicit_content_filter"`
+		VerificationLevel        string `yaml:"verification_level"`         // none, low, medium, high, very_high
+		DefaultNotificationLevel string `yaml:"default_notification_level"` // all_messages, only_mentions
+		ExplicitContentFilter    string `yaml:"explicit_content_filter"`    // disabled, members_without_roles, all_members
 	} `yaml:"settings"`
 	Features struct {
 		Community    bool `yaml:"community"`
 		Discoverable bool `yaml:"discoverable"`
 	} `yaml:"features"`
-	VanityURL string `yaml:"vanity_url,omitempty"`
+	Channels  SpecialChannels `yaml:"channels"`
+	VanityURL string          `yaml:"vanity_url,omitempty"`
+	Pruning   PruningConfig   `yaml:"pruning"`
 }
 
-// ChannelsConfig holds channel structure
+// SpecialChannels assigns channels from channels.yaml (by name or key) to
+// the guild's special purposes. Anything left empty is left as it is in
+// Discord.
+type SpecialChannels struct {
+	System             string   `yaml:"system,omitempty"`         // join and boost messages
+	Rules              string   `yaml:"rules,omitempty"`          // required for community
+	PublicUpdates      string   `yaml:"public_updates,omitempty"` // required for community
+	AFK                string   `yaml:"afk,omitempty"`            // a voice channel
+	AFKTimeout         int      `yaml:"afk_timeout,omitempty"`    // seconds: 60, 300, 900, 1800, 3600
+	SystemChannelFlags []string `yaml:"system_channel_flags,omitempty"`
+}
+
+// Pruning modes for resources that exist in the guild but not in config
+const (
+	PruneOff    = "off"    // ignore unmanaged resources
+	PruneReport = "report" // list unmanaged resources without deleting them
+	PruneDelete = "delete" // delete unmanaged resources
+)
+
+// PruningConfig controls what sync does with unmanaged roles and channels
+type PruningConfig struct {
+	Mode      string   `yaml:"mode"`      // off, report, delete (default report)
+	Protected []string `yaml:"protected"` // names or IDs that are never deleted
+}
+
+// ChannelsConfig holds channel structure. Channels outside any category sit
+// above the categories in the sidebar.
 type ChannelsConfig struct {
+	Channels   []Channel  `yaml:"channels,omitempty"`
 	Categories []Category `yaml:"categories"`
 }
 
 type Category struct {
-	Name     string    `yaml:"name"`
-	Position int       `yaml:"position"`
-	Channels []Channel `yaml:"channels"`
+	Name          string                   `yaml:"name"`
+	Key           string                   `yaml:"key,omitempty"`
+	PreviousNames []string                 `yaml:"previous_names,omitempty"`
+	Position      int                      `yaml:"position"`
+	Permissions   map[string]PermissionSet `yaml:"permissions,omitempty"`
+	Channels      []Channel                `yaml:"channels"`
 }
 
 type Channel struct {
-	Name        string                       `yaml:"name"`
-	Type        string                       `yaml:"type"` // text, voice, forum
-	Topic       string                       `yaml:"topic,omitempty"`
-	Position    int                          `yaml:"position"`
-	Permissions map[string]map[string]bool   `yaml:"permissions,omitempty"`
-	Tags        []ForumTag                   `yaml:"available_tags,omitempty"`
+	Name          string                   `yaml:"name"`
+	Key           string                   `yaml:"key,omitempty"`
+	PreviousNames []string                 `yaml:"previous_names,omitempty"`
+	Type          string                   `yaml:"type"`            // text, voice, forum, announcement, stage, media
+	Topic         string                   `yaml:"topic,omitempty"` // post guidelines, for forums
+	Position      int                      `yaml:"position"`
+	Permissions   map[string]PermissionSet `yaml:"permissions,omitempty"`
+
+	// Message and thread settings
+	Slowmode              int  `yaml:"slowmode,omitempty"`                // seconds between messages per member
+	NSFW                  bool `yaml:"nsfw,omitempty"`                    // age-restricted
+	DefaultAutoArchive    int  `yaml:"default_auto_archive,omitempty"`    // minutes: 60, 1440, 4320, 10080
+	DefaultThreadSlowmode int  `yaml:"default_thread_slowmode,omitempty"` // slowmode for new threads, in seconds
+
+	// Voice and stage channel settings
+	Bitrate      int    `yaml:"bitrate,omitempty"`       // bits per second
+	UserLimit    int    `yaml:"user_limit,omitempty"`    // 0 = unlimited
+	RTCRegion    string `yaml:"rtc_region,omitempty"`    // automatic if empty
+	VideoQuality string `yaml:"video_quality,omitempty"` // auto (default), full
+
+	// Forum and media channel settings
+	DefaultReaction string     `yaml:"default_reaction,omitempty"` // emoji, or a custom emoji ID
+	SortOrder       string     `yaml:"sort_order,omitempty"`       // latest_activity, creation_date
+	Layout          string     `yaml:"layout,omitempty"`           // not_set, list_view, gallery_view
+	RequireTag      bool       `yaml:"require_tag,omitempty"`
+	Tags            []ForumTag `yaml:"available_tags,omitempty"`
 }
 
+// ForumTag is a tag that posts in a forum channel can be given. Tags are
+// tracked by ID, so renaming one keeps it on existing posts.
 type ForumTag struct {
-	Name  string `yaml:"name"`
-	Emoji string `yaml:"emoji"`
+	Name          string   `yaml:"name"`
+	Key           string   `yaml:"key,omitempty"`
+	PreviousNames []string `yaml:"previous_names,omitempty"`
+	Emoji         string   `yaml:"emoji,omitempty"`     // emoji, or a custom emoji ID
+	Moderated     bool     `yaml:"moderated,omitempty"` // only moderators can apply it
 }
 
 // RolesConfig holds role definitions
@@ -67,12 +135,15 @@ type RolesConfig struct {
 }
 
 type Role struct {
-	Name        string   `yaml:"name"`
-	Color       string   `yaml:"color"`
-	Permissions []string `yaml:"permissions"`
-	Hoist       bool     `yaml:"hoist"`
-	Mentionable bool     `yaml:"mentionable"`
-	Description string   `yaml:"description,omitempty"`
+	Name          string         `yaml:"name"`
+	Key           string         `yaml:"key,omitempty"`
+	PreviousNames []string       `yaml:"previous_names,omitempty"`
+	Position      int            `yaml:"position,omitempty"` // 1 = highest; defaults to file order
+	Color         string         `yaml:"color"`
+	Permissions   PermissionList `yaml:"permissions"`
+	Hoist         bool           `yaml:"hoist"`
+	Mentionable   bool           `yaml:"mentionable"`
+	Description   string         `yaml:"description,omitempty"`
 }
 
 // IntegrationsConfig holds webhook and integration settings
@@ -86,52 +157,94 @@ type GitHubIntegration struct {
 	Events        []string `yaml:"events"`
 }
 
+// Dir is where the configuration files live
+const Dir = "config"
+
 // Load reads all configuration files and environment variables
 func Load() (*Config, error) {
-	cfg := &Config{}
-
-	// Load environment variables
-	cfg.BotToken = os.Getenv("DISCORD_BOT_TOKEN")
-	if cfg.BotToken == "" {
-		return nil, fmt.Errorf("DISCORD_BOT_TOKEN environment variable is required")
-	}
+	return LoadDir(Dir)
+}
 
-	cfg.GuildID = os.Getenv("DISCORD_GUILD_ID")
-	if cfg.GuildID == "" {
-		return nil, fmt.Errorf("DISCORD_GUILD_ID environment variable is required")
+// LoadDir reads the configuration files in configDir, such as a backup
+// directory, along with the environment variables
+func LoadDir(configDir string) (*Config, error) {
+	cfg, err := LoadEnv()
+	if err != nil {
+		return nil, err
 	}
 
 	// Load YAML config files
-	configDir := "config"
-
-	if err := loadYAML(filepath.Join(configDir, "server.yaml"), &cfg.Server); err != nil {
+	if err := cfg.load(filepath.Join(configDir, "server.yaml"), &cfg.Server); err != nil {
 		return nil, fmt.Errorf("loading server config: %w", err)
 	}
 
-	if err := loadYAML(filepath.Join(configDir, "channels.yaml"), &cfg.Channels); err != nil {
+	// Permission sets are optional
+	setsPath := filepath.Join(configDir, "permissions.yaml")
+	if _, err := os.Stat(setsPath); err == nil {
+		if err := cfg.load(setsPath, &cfg.PermissionSets); err != nil {
+			return nil, fmt.Errorf("loading permission sets: %w", err)
+		}
+	}
+
+	if err := cfg.load(filepath.Join(configDir, "channels.yaml"), &cfg.Channels); err != nil {
 		return nil, fmt.Errorf("loading channels config: %w", err)
 	}
 
-	if err := loadYAML(filepath.Join(configDir, "roles.yaml"), &cfg.Roles); err != nil {
+	if err := cfg.load(filepath.Join(configDir, "roles.yaml"), &cfg.Roles); err != nil {
 		return nil, fmt.Errorf("loading roles config: %w", err)
 	}
 
-	if err := loadYAML(filepath.Join(configDir, "integrations.yaml"), &cfg.Integrations); err != nil {
-		return nil, fmt.Errorf("loading integrations config: %w", err)
+	// Integrations are optional, since backups don't include them
+	integrationsPath := filepath.Join(configDir, "integrations.yaml")
+	if _, err := os.Stat(integrationsPath); err == nil {
+		if err := cfg.load(integrationsPath, &cfg.Integrations); err != nil {
+			return nil, fmt.Errorf("loading integrations config: %w", err)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("validating config: %w", err)
 	}
 
 	return cfg, nil
 }
 
-func loadYAML(path string, v interface{}) error {
+// LoadEnv reads only the environment variables, for commands that run
+// before there is a config to load
+func LoadEnv() (*Config, error) {
+	cfg := &Config{}
+
+	cfg.BotToken = os.Getenv("DISCORD_BOT_TOKEN")
+	if cfg.BotToken == "" {
+		return nil, fmt.Errorf("DISCORD_BOT_TOKEN environment variable is required")
+	}
+
+	cfg.GuildID = os.Getenv("DISCORD_GUILD_ID")
+	if cfg.GuildID == "" {
+		return nil, fmt.Errorf("DISCORD_GUILD_ID environment variable is required")
+	}
+
+	return cfg, nil
+}
+
+// load decodes a config file into v, keeping its YAML for validation
+func (c *Config) load(path string, v interface{}) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("reading %s: %w", path, err)
 	}
 
-	if err := yaml.Unmarshal(data, v); err != nil {
+	src := source{path: path}
+	if err := yaml.Unmarshal(data, &src.doc); err != nil {
 		return fmt.Errorf("parsing %s: %w", path, err)
 	}
+	// An empty file has no document to decode
+	if src.doc.Kind != 0 {
+		if err := src.doc.Decode(v); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	c.sources = append(c.sources, src)
 
 	return nil
 }
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// testConfig returns a valid config to break in tests
+func testConfig() *Config {
+	return &Config{
+		Roles: RolesConfig{Roles: []Role{
+			{Name: "Admin"},
+			{Name: "Member"},
+		}},
+		Channels: ChannelsConfig{Categories: []Category{{
+			Name: "COMMUNITY",
+			Channels: []Channel{
+				{Name: "general", Type: "text"},
+				{Name: "announcements", Type: "text"},
+			},
+		}}},
+	}
+}
+
+// checkInvalid fails unless cfg is rejected with an error containing want
+func checkInvalid(t *testing.T, cfg *Config, want string) {
+	t.Helper()
+	err := cfg.validate()
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want one containing %q", err, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testConfig().validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateIdentities(t *testing.T) {
+	cfg := testConfig()
+	cfg.Roles.Roles[1].Name = "Admin"
+	checkInvalid(t, cfg, `role "Admin" is already used by "Admin"`)
+
+	cfg = testConfig()
+	cfg.Roles.Roles[1].PreviousNames = []string{"Admin"}
+	checkInvalid(t, cfg, `role "Member" previous name "Admin" is already used by "Admin"`)
+
+	cfg = testConfig()
+	cfg.Channels.Categories[0].Channels[1].Key = "general"
+	checkInvalid(t, cfg, `channel "general" is already used by "general"`)
+
+	// A key frees the name for another entry
+	cfg = testConfig()
+	cfg.Roles.Roles[0].Key = "admins"
+	cfg.Roles.Roles[1].PreviousNames = []string{"Admin"}
+	if err := cfg.validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateGitHubTarget(t *testing.T) {
+	cfg := testConfig()
+	cfg.Integrations.GitHub = &GitHubIntegration{Enabled: true, TargetChannel: "github"}
+	checkInvalid(t, cfg, `target_channel "github" is not in channels.yaml`)
+
+	cfg.Integrations.GitHub.TargetChannel = "general"
+	if err := cfg.validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidatePruning(t *testing.T) {
+	cfg := testConfig()
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Pruning.Mode != PruneReport {
+		t.Errorf("pruning mode defaults to %q, want %q", cfg.Server.Pruning.Mode, PruneReport)
+	}
+
+	cfg = testConfig()
+	cfg.Server.Pruning.Mode = "sometimes"
+	checkInvalid(t, cfg, `invalid pruning mode "sometimes"`)
+}
+
+func TestValidateTargets(t *testing.T) {
+	cfg := testConfig()
+	cfg.Channels.Categories[0].Channels[0].Permissions = map[string]PermissionSet{
+		EveryoneTarget:        {"send_messages": false},
+		"Member":              {"send_messages": true},
+		"user:80351110224678": {"send_messages": true},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Channels.Categories[0].Channels[0].Permissions = map[string]PermissionSet{"Moderator": {}}
+	checkInvalid(t, cfg, `general permissions: unknown role "Moderator"`)
+
+	cfg.Channels.Categories[0].Channels[0].Permissions = map[string]PermissionSet{"user:alice": {}}
+	checkInvalid(t, cfg, `general permissions: "user:alice" is not a valid user ID`)
+}
+
+func TestEffectivePermissions(t *testing.T) {
+	category := Category{Permissions: map[string]PermissionSet{
+		EveryoneTarget: {"view_channel": false},
+		"Member":       {"view_channel": true},
+	}}
+
+	// Without its own permissions a channel inherits the category's
+	if got := category.EffectivePermissions(Channel{}); !reflect.DeepEqual(got, category.Permissions) {
+		t.Errorf("inherited %v", got)
+	}
+
+	got := category.EffectivePermissions(Channel{Permissions: map[string]PermissionSet{
+		"Member":    {"send_messages": false},
+		"Moderator": {"send_messages": true},
+	}})
+	want := map[string]PermissionSet{
+		EveryoneTarget: {"view_channel": false},
+		"Member":       {"view_channel": true, "send_messages": false},
+		"Moderator":    {"send_messages": true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(category.Permissions["Member"]) != 1 {
+		t.Error("merging changed the category's permissions")
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	cfg := testConfig()
+	cfg.Server.Settings.VerificationLevel = "very_high"
+	cfg.Server.Settings.DefaultNotificationLevel = "all_messages"
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Server.Settings.ExplicitContentFilter = "everyone"
+	checkInvalid(t, cfg, `invalid explicit_content_filter "everyone" (want one of: disabled, members_without_roles, all_members)`)
+}
+
+func TestSettingNames(t *testing.T) {
+	if got := SettingValue(VerificationLevels, "high"); got != 3 {
+		t.Errorf("high is %d, want 3", got)
+	}
+	if got := SettingName(VerificationLevels, 3); got != "high" {
+		t.Errorf("3 is %q, want high", got)
+	}
+	// A level Discord added later has no name
+	if got := SettingName(VerificationLevels, 7); got != "7" {
+		t.Errorf("7 is %q", got)
+	}
+}
+
+func TestValidateChannels(t *testing.T) {
+	cfg := testConfig()
+	cfg.Channels.Categories[0].Channels = append(cfg.Channels.Categories[0].Channels,
+		Channel{Name: "lounge", Type: "voice"},
+		Channel{Name: "news", Type: "announcement"},
+	)
+	cfg.Server.Features.Community = true
+	cfg.Server.Channels = SpecialChannels{
+		System:             "general",
+		Rules:              "announcements",
+		PublicUpdates:      "news",
+		AFK:                "lounge",
+		AFKTimeout:         300,
+		SystemChannelFlags: []string{"suppress_join_notifications"},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := *cfg
+	broken.Server.Channels.System = "welcome"
+	checkInvalid(t, &broken, `system channel "welcome" is not in channels.yaml`)
+
+	broken = *cfg
+	broken.Server.Channels.AFK = "general"
+	checkInvalid(t, &broken, `afk channel "general" has type text (want voice)`)
+
+	broken = *cfg
+	broken.Server.Channels.System = "news"
+	checkInvalid(t, &broken, `system channel "news" has type announcement (want text)`)
+
+	broken = *cfg
+	broken.Server.Channels.Rules = ""
+	checkInvalid(t, &broken, "community servers need a rules and a public_updates channel")
+
+	broken = *cfg
+	broken.Server.Channels.AFKTimeout = 120
+	checkInvalid(t, &broken, "invalid afk_timeout 120")
+
+	broken = *cfg
+	broken.Server.Channels.SystemChannelFlags = []string{"suppress_everything"}
+	checkInvalid(t, &broken, `unknown system_channel_flags entry "suppress_everything"`)
+}
+
+func TestFlagNames(t *testing.T) {
+	names, unknown := FlagNames(1 | 1<<2 | 1<<10)
+	if want := []string{"suppress_join_notifications", "suppress_guild_reminder_notifications"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names %v, want %v", names, want)
+	}
+	if unknown != 1<<10 {
+		t.Errorf("unknown 0x%x, want 0x400", unknown)
+	}
+	if got := FlagBits(names); got != 1|1<<2 {
+		t.Errorf("bits 0x%x, want 0x5", got)
+	}
+}
+
+func TestValidateChannelTypes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Channels.Categories[0].Channels = []Channel{
+		{Name: "news", Type: "announcement"},
+		{Name: "town-hall", Type: "stage"},
+		{Name: "gallery", Type: "media", Tags: []ForumTag{{Name: "art"}}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Channels.Categories[0].Channels[1].Type = "news"
+	checkInvalid(t, cfg, `channel "town-hall": unknown type "news" (want one of: text, voice, forum, announcement, stage, media)`)
+
+	cfg = testConfig()
+	cfg.Channels.Categories[0].Channels[0].Tags = []ForumTag{{Name: "help"}}
+	checkInvalid(t, cfg, `channel "general": available_tags, default_reaction, sort_order, layout, and require_tag only apply to forum and media channels`)
+
+	cfg = testConfig()
+	cfg.Channels.Categories[0].Channels[0].Type = "media"
+	cfg.Channels.Categories[0].Channels[0].Layout = "grid"
+	checkInvalid(t, cfg, `channel "general": layout only applies to forum channels`)
+}
+
+func TestChannelTypeNames(t *testing.T) {
+	for _, ct := range channelTypes {
+		if name, ok := ChannelTypeName(ct.typ); !ok || name != ct.name {
+			t.Errorf("type %d is named %q, want %q", ct.typ, name, ct.name)
+		}
+	}
+	// Threads and DMs can't be managed
+	if name, ok := ChannelTypeName(discordgo.ChannelTypeGuildPublicThread); ok {
+		t.Errorf("public threads are named %q", name)
+	}
+}
+
+func TestValidateChannelSettings(t *testing.T) {
+	cfg := testConfig()
+	cfg.Channels.Categories[0].Channels = []Channel{
+		{Name: "general", Type: "text", Slowmode: 10, DefaultAutoArchive: 1440, DefaultThreadSlowmode: 60},
+		{Name: "lounge", Type: "voice", Bitrate: 96000, UserLimit: 25, VideoQuality: "full"},
+		{Name: "town-hall", Type: "stage", UserLimit: 500},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		ch   Channel
+		want string
+	}{
+		{Channel{Type: "announcement", Slowmode: 10}, "announcement channels don't support slowmode"},
+		{Channel{Type: "voice", DefaultAutoArchive: 60}, "default_auto_archive only applies to channels with threads"},
+		{Channel{Type: "announcement", DefaultThreadSlowmode: 5}, "default_thread_slowmode only applies to text, forum, and media channels"},
+		{Channel{Type: "text", UserLimit: 5}, "user_limit, rtc_region, and video_quality only apply to voice and stage channels"},
+		{Channel{Type: "text", Slowmode: 21601}, "slowmode must be between 0 and 21600 seconds"},
+		{Channel{Type: "text", DefaultAutoArchive: 100}, "invalid default_auto_archive 100"},
+		{Channel{Type: "voice", Bitrate: 1000}, "bitrate must be between 8000 and 384000"},
+		{Channel{Type: "voice", UserLimit: 100}, "user_limit must be between 0 (unlimited) and 99"},
+		{Channel{Type: "voice", VideoQuality: "hd"}, `invalid video_quality "hd"`},
+	} {
+		tc.ch.Name = "test"
+		cfg := testConfig()
+		cfg.Channels.Categories[0].Channels = []Channel{tc.ch}
+		checkInvalid(t, cfg, tc.want)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	t.Setenv("DISCORD_BOT_TOKEN", "token")
+	t.Setenv("DISCORD_GUILD_ID", "1")
+
+	// Backups have no integrations.yaml
+	dir := t.TempDir()
+	files := map[string]string{
+		"server.yaml":   `name: "Test"`,
+		"roles.yaml":    "roles:\n  - name: \"Member\"\n",
+		"channels.yaml": "categories:\n  - name: \"COMMUNITY\"\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Name != "Test" || cfg.GuildID != "1" || len(cfg.Roles.Roles) != 1 || cfg.Integrations.GitHub != nil {
+		t.Errorf("loaded %+v", cfg)
+	}
+}
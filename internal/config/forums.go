@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Forum setting names, indexed by their Discord enum value
+var (
+	ForumSortOrders = []string{"latest_activity", "creation_date"}
+	ForumLayouts    = []string{"not_set", "list_view", "gallery_view"}
+)
+
+// maxForumTags is the most tags Discord allows on a forum
+const maxForumTags = 20
+
+// ParseEmoji splits an emoji from config into a custom emoji ID or a
+// unicode emoji. Exactly one of the two is set, or neither if s is empty.
+func ParseEmoji(s string) (id, name string) {
+	if isSnowflake(s) {
+		return s, ""
+	}
+	return "", s
+}
+
+// validateForum checks a channel's forum settings: they only apply to
+// forum and media channels (layout only to forums), enum values must be
+// known, and tag identities must be unique
+func validateForum(ch Channel) error {
+	if !HasTags(ch.Type) {
+		if len(ch.Tags) > 0 || ch.DefaultReaction != "" || ch.SortOrder != "" || ch.Layout != "" || ch.RequireTag {
+			return fmt.Errorf("channel %q: available_tags, default_reaction, sort_order, layout, and require_tag only apply to forum and media channels", ch.Name)
+		}
+		return nil
+	}
+	if ch.Type != "forum" && ch.Layout != "" {
+		return fmt.Errorf("channel %q: layout only applies to forum channels", ch.Name)
+	}
+
+	settings := []struct {
+		name  string
+		value string
+		names []string
+	}{
+		{"sort_order", ch.SortOrder, ForumSortOrders},
+		{"layout", ch.Layout, ForumLayouts},
+	}
+	for _, setting := range settings {
+		if setting.value != "" && SettingValue(setting.names, setting.value) < 0 {
+			return fmt.Errorf("channel %q: invalid %s %q (want one of: %s)",
+				ch.Name, setting.name, setting.value, strings.Join(setting.names, ", "))
+		}
+	}
+
+	if len(ch.Tags) > maxForumTags {
+		return fmt.Errorf("channel %q: %d available_tags (Discord allows at most %d)", ch.Name, len(ch.Tags), maxForumTags)
+	}
+	if ch.RequireTag && len(ch.Tags) == 0 {
+		return fmt.Errorf("channel %q: require_tag needs at least one entry in available_tags", ch.Name)
+	}
+
+	tags := newIdentities(fmt.Sprintf("channel %q tag", ch.Name))
+	for _, tag := range ch.Tags {
+		if err := tags.claim(tag.StateKey(), tag.PreviousNames); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package config
+
+// StateKey returns the stable identity of a category: its key if set,
+// otherwise its name. Changing the name of a keyed category renames it in
+// place instead of replacing it.
+func (c Category) StateKey() string {
+	if c.Key != "" {
+		return c.Key
+	}
+	return c.Name
+}
+
+// StateKey returns the stable identity of a channel: its key if set,
+// otherwise its name
+func (c Channel) StateKey() string {
+	if c.Key != "" {
+		return c.Key
+	}
+	return c.Name
+}
+
+// StateKey returns the stable identity of a forum tag within its channel:
+// its key if set, otherwise its name
+func (t ForumTag) StateKey() string {
+	if t.Key != "" {
+		return t.Key
+	}
+	return t.Name
+}
+
+// StateKey returns the stable identity of a role: its key if set,
+// otherwise its name
+func (r Role) StateKey() string {
+	if r.Key != "" {
+		return r.Key
+	}
+	return r.Name
+}
+
+// FindChannel returns the channel whose key or name matches ref, or nil
+func (c *ChannelsConfig) FindChannel(ref string) *Channel {
+	for i := range c.Channels {
+		if ch := &c.Channels[i]; ch.Key == ref || ch.Name == ref {
+			return ch
+		}
+	}
+	for i := range c.Categories {
+		for j := range c.Categories[i].Channels {
+			ch := &c.Categories[i].Channels[j]
+			if ch.Key == ref || ch.Name == ref {
+				return ch
+			}
+		}
+	}
+
+	return nil
+}
+
+// FindRole returns the role whose key or name matches ref, or nil
+func (r *RolesConfig) FindRole(ref string) *Role {
+	for i := range r.Roles {
+		if r.Roles[i].Key == ref || r.Roles[i].Name == ref {
+			return &r.Roles[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,307 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Work-Fort/Discord/internal/permissions"
+	"gopkg.in/yaml.v3"
+)
+
+// Channel permission overwrite targets. Any other key names a role from
+// roles.yaml by name or key.
+const (
+	EveryoneTarget     = "everyone" // the @everyone role
+	MemberTargetPrefix = "user:"    // followed by a member's user ID
+)
+
+// PermissionSetsConfig holds named permission sets. A set's name can be used
+// wherever a permission name can: in a role's permissions, in a channel or
+// category overwrite, and in another set, which then extends it.
+type PermissionSetsConfig struct {
+	Sets map[string]PermissionSet `yaml:"permission_sets"`
+}
+
+// PermissionList is a list of permission names, as granted by a role. An
+// entry may name a permission set, which grants every permission it allows.
+type PermissionList []string
+
+// PermissionSet maps permission names to allow (true) or deny (false), as
+// used by channel permission overwrites. A permission set name mapped to
+// true includes that set; the other entries override it.
+type PermissionSet map[string]bool
+
+// isSet reports whether name is a permission set from permissions.yaml
+func (c *Config) isSet(name string) bool {
+	_, ok := c.PermissionSets.Sets[name]
+	return ok
+}
+
+// checkPermissionNames rejects unknown permission names in the loaded
+// files, reporting their line. It walks each file's YAML, since names are
+// only known once permissions.yaml has been read: every "permissions" list
+// (a role's) or mapping of targets (overwrites), and every permission set.
+func (c *Config) checkPermissionNames() error {
+	for _, src := range c.sources {
+		if err := c.checkNode(&src.doc); err != nil {
+			return fmt.Errorf("%s: %w", src.path, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) checkNode(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		// Mapping node content alternates keys and values
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			var err error
+			switch key.Value {
+			case "permissions":
+				err = c.checkPermissions(value)
+			case "permission_sets":
+				if err = c.checkSetNames(value); err == nil {
+					err = c.checkSets(value)
+				}
+			default:
+				err = c.checkNode(value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, child := range node.Content {
+		if err := c.checkNode(child); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPermissions checks a role's permission list, or the sets of a
+// channel or category's overwrites
+func (c *Config) checkPermissions(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := c.checkPermission(item); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		return c.checkSets(node)
+	}
+
+	return nil
+}
+
+// checkSets checks each permission set in a mapping, whether named sets or
+// overwrites keyed by target
+func (c *Config) checkSets(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 1; i < len(node.Content); i += 2 {
+		set := node.Content[i]
+		for j := 0; j+1 < len(set.Content); j += 2 {
+			key, value := set.Content[j], set.Content[j+1]
+			if err := c.checkPermission(key); err != nil {
+				return err
+			}
+			var included bool
+			if c.isSet(key.Value) && (value.Decode(&included) != nil || !included) {
+				return fmt.Errorf("line %d: permission set %q can only be included (true), not denied", key.Line, key.Value)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) checkPermission(node *yaml.Node) error {
+	if _, ok := permissions.Lookup(node.Value); !ok && !c.isSet(node.Value) {
+		if len(c.PermissionSets.Sets) > 0 {
+			return fmt.Errorf("line %d: unknown permission or permission set %q", node.Line, node.Value)
+		}
+		return fmt.Errorf("line %d: unknown permission %q", node.Line, node.Value)
+	}
+
+	return nil
+}
+
+// checkSetNames rejects permission sets named like a permission, since
+// references to them would be ambiguous
+func (c *Config) checkSetNames(node *yaml.Node) error {
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if _, ok := permissions.Lookup(key.Value); ok {
+			return fmt.Errorf("line %d: permission set %q has the same name as a permission", key.Line, key.Value)
+		}
+	}
+
+	return nil
+}
+
+// resolvePermissionSets replaces every permission set reference in roles,
+// categories, and channels with the permissions it stands for, so the rest
+// of the tool only ever sees permission names. It fails on a set that
+// (directly or not) includes itself.
+func (c *Config) resolvePermissionSets() error {
+	resolved := make(map[string]PermissionSet)
+	for _, name := range sortedSetNames(c.PermissionSets.Sets) {
+		if _, err := c.resolveSet(name, resolved, nil); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Roles.Roles {
+		role := &c.Roles.Roles[i]
+		role.Permissions = expandList(role.Permissions, resolved)
+	}
+
+	for i := range c.Channels.Channels {
+		expandOverwrites(c.Channels.Channels[i].Permissions, resolved)
+	}
+	for i := range c.Channels.Categories {
+		category := &c.Channels.Categories[i]
+		expandOverwrites(category.Permissions, resolved)
+		for j := range category.Channels {
+			expandOverwrites(category.Channels[j].Permissions, resolved)
+		}
+	}
+
+	return nil
+}
+
+// resolveSet returns a set with its included sets merged in. path holds the
+// sets being resolved further up, to detect cycles.
+func (c *Config) resolveSet(name string, resolved map[string]PermissionSet, path []string) (PermissionSet, error) {
+	if set, ok := resolved[name]; ok {
+		return set, nil
+	}
+	for i, seen := range path {
+		if seen == name {
+			return nil, fmt.Errorf("permission set cycle: %s", strings.Join(append(path[i:], name), " → "))
+		}
+	}
+
+	set := c.PermissionSets.Sets[name]
+	included := make(map[string]PermissionSet)
+	for _, ref := range sortedSetNames(set) {
+		if !c.isSet(ref) {
+			continue
+		}
+		sub, err := c.resolveSet(ref, resolved, append(path, name))
+		if err != nil {
+			return nil, err
+		}
+		included[ref] = sub
+	}
+
+	resolved[name] = merge(set, included)
+	return resolved[name], nil
+}
+
+// expandList replaces set names in a role's permission list with the
+// permissions each set allows, keeping the first occurrence of each
+func expandList(list PermissionList, resolved map[string]PermissionSet) PermissionList {
+	var out PermissionList
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+
+	for _, name := range list {
+		set, ok := resolved[name]
+		if !ok {
+			add(name)
+			continue
+		}
+		for _, perm := range sortedSetNames(set) {
+			if set[perm] {
+				add(perm)
+			}
+		}
+	}
+
+	return out
+}
+
+// expandOverwrites replaces set references in each overwrite with the
+// permissions of the included sets
+func expandOverwrites(perms map[string]PermissionSet, resolved map[string]PermissionSet) {
+	for target, set := range perms {
+		included := make(map[string]PermissionSet)
+		for name := range set {
+			if sub, ok := resolved[name]; ok {
+				included[name] = sub
+			}
+		}
+		if len(included) > 0 {
+			perms[target] = merge(set, included)
+		}
+	}
+}
+
+// merge returns the permissions of the included sets, in name order, with
+// the set's own permissions layered on top. References to included sets
+// are dropped.
+func merge(set PermissionSet, included map[string]PermissionSet) PermissionSet {
+	out := make(PermissionSet)
+	for _, name := range sortedSetNames(set) {
+		for perm, value := range included[name] {
+			out[perm] = value
+		}
+	}
+	for perm, value := range set {
+		if _, ok := included[perm]; !ok {
+			out[perm] = value
+		}
+	}
+
+	return out
+}
+
+func sortedSetNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// EffectivePermissions returns the overwrites a channel should have. A
+// channel without its own permissions inherits its category's and stays
+// synced with it. Otherwise the channel's permissions are layered over the
+// category's, per target and per permission.
+func (c Category) EffectivePermissions(ch Channel) map[string]PermissionSet {
+	if ch.Permissions == nil {
+		return c.Permissions
+	}
+
+	merged := make(map[string]PermissionSet)
+	for _, perms := range []map[string]PermissionSet{c.Permissions, ch.Permissions} {
+		for target, set := range perms {
+			if merged[target] == nil {
+				merged[target] = make(PermissionSet)
+			}
+			for perm, value := range set {
+				merged[target][perm] = value
+			}
+		}
+	}
+
+	return merged
+}
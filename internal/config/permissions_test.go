@@ -0,0 +1,143 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadFiles loads a config directory holding files, over a minimal valid
+// config
+func loadFiles(t *testing.T, files map[string]string) (*Config, error) {
+	t.Helper()
+	t.Setenv("DISCORD_BOT_TOKEN", "token")
+	t.Setenv("DISCORD_GUILD_ID", "1")
+
+	dir := t.TempDir()
+	base := map[string]string{
+		"server.yaml":   `name: "Test"`,
+		"roles.yaml":    "roles:\n  - name: \"Member\"\n",
+		"channels.yaml": "categories:\n  - name: \"COMMUNITY\"\n",
+	}
+	maps.Copy(base, files)
+	for name, data := range base {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return LoadDir(dir)
+}
+
+// checkLoadError checks that loading files fails at a line of one of them
+func checkLoadError(t *testing.T, files map[string]string, file, want string) {
+	t.Helper()
+	_, err := loadFiles(t, files)
+	if err == nil || !strings.HasSuffix(err.Error(), file+": "+want) {
+		t.Errorf("got error %v, want %s: %s", err, file, want)
+	}
+}
+
+func TestPermissionNames(t *testing.T) {
+	checkLoadError(t, map[string]string{"roles.yaml": `
+roles:
+  - name: Member
+    permissions:
+      - send_messages
+      - read_messages
+`}, "roles.yaml", `line 6: unknown permission "read_messages"`)
+
+	checkLoadError(t, map[string]string{"channels.yaml": `
+categories:
+  - name: COMMUNITY
+    channels:
+      - name: general
+        type: text
+        permissions:
+          everyone:
+            send_messages: false
+            post_messages: false
+`}, "channels.yaml", `line 10: unknown permission "post_messages"`)
+
+	_, err := loadFiles(t, map[string]string{"roles.yaml": `
+roles:
+  - name: Member
+    permissions: [view_channel, send_messages]
+`})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPermissionSetNames(t *testing.T) {
+	sets := `
+permission_sets:
+  read_only:
+    send_messages: false
+`
+
+	checkLoadError(t, map[string]string{"permissions.yaml": sets, "channels.yaml": `
+categories:
+  - name: COMMUNITY
+    permissions:
+      everyone:
+        read_only: true
+      Member:
+        read_onyl: true
+`}, "channels.yaml", `line 8: unknown permission or permission set "read_onyl"`)
+
+	checkLoadError(t, map[string]string{"permissions.yaml": sets, "channels.yaml": `
+categories:
+  - name: COMMUNITY
+    permissions:
+      everyone:
+        read_only: false
+`}, "channels.yaml", `line 6: permission set "read_only" can only be included (true), not denied`)
+
+	// Sets are checked too, and can't be named like a permission
+	checkLoadError(t, map[string]string{"permissions.yaml": `
+permission_sets:
+  read_only:
+    send_mesages: false
+`}, "permissions.yaml", `line 4: unknown permission or permission set "send_mesages"`)
+}
+
+func TestResolvePermissionSets(t *testing.T) {
+	cfg := testConfig()
+	cfg.PermissionSets.Sets = map[string]PermissionSet{
+		"read_only": {"send_messages": false, "add_reactions": true},
+		"member":    {"send_messages": true, "add_reactions": true},
+		// Extends member, and its own entries win
+		"moderator": {"member": true, "manage_messages": true, "add_reactions": false},
+	}
+	cfg.Roles.Roles[0].Permissions = PermissionList{"moderator", "send_messages"}
+	cfg.Channels.Categories[0].Permissions = map[string]PermissionSet{
+		EveryoneTarget: {"read_only": true, "add_reactions": false},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sets in a role's list grant what they allow, without duplicates
+	if got, want := cfg.Roles.Roles[0].Permissions, (PermissionList{"manage_messages", "send_messages"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("role permissions %v, want %v", got, want)
+	}
+
+	want := PermissionSet{"send_messages": false, "add_reactions": false}
+	if got := cfg.Channels.Categories[0].Permissions[EveryoneTarget]; !reflect.DeepEqual(got, want) {
+		t.Errorf("overwrite %v, want %v", got, want)
+	}
+}
+
+func TestPermissionSetCycle(t *testing.T) {
+	cfg := testConfig()
+	cfg.PermissionSets.Sets = map[string]PermissionSet{
+		"a": {"b": true},
+		"b": {"c": true},
+		"c": {"a": true, "send_messages": true},
+	}
+	checkInvalid(t, cfg, "permission set cycle: a → b → c → a")
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Server setting names, indexed by their Discord enum value
+var (
+	VerificationLevels        = []string{"none", "low", "medium", "high", "very_high"}
+	DefaultNotificationLevels = []string{"all_messages", "only_mentions"}
+	ExplicitContentFilters    = []string{"disabled", "members_without_roles", "all_members"}
+)
+
+// SystemChannelFlags are the system channel flag names, indexed by bit
+var SystemChannelFlags = []string{
+	"suppress_join_notifications",
+	"suppress_premium_subscriptions",
+	"suppress_guild_reminder_notifications",
+	"suppress_join_notification_replies",
+	"suppress_role_subscription_purchase_notifications",
+	"suppress_role_subscription_purchase_notification_replies",
+}
+
+// AFKTimeouts are the AFK timeouts Discord accepts, in seconds
+var AFKTimeouts = []int{60, 300, 900, 1800, 3600}
+
+// FlagBits returns the bitfield for a list of system channel flag names.
+// Unknown names are ignored; validation rejects them.
+func FlagBits(names []string) int {
+	var bits int
+	for _, name := range names {
+		if bit := SettingValue(SystemChannelFlags, name); bit >= 0 {
+			bits |= 1 << bit
+		}
+	}
+
+	return bits
+}
+
+// FlagNames returns the system channel flag names set in a bitfield, in bit
+// order, along with any set bits that have no name, which validation would
+// reject
+func FlagNames(bits int) (names []string, unknown int) {
+	for bit := 0; bits>>bit != 0; bit++ {
+		if bits&(1<<bit) == 0 {
+			continue
+		}
+		if bit < len(SystemChannelFlags) {
+			names = append(names, SystemChannelFlags[bit])
+		} else {
+			unknown |= 1 << bit
+		}
+	}
+
+	return names, unknown
+}
+
+// SettingValue returns the Discord enum value of a setting name, or -1 if
+// the name is unknown
+func SettingValue(names []string, name string) int {
+	for value, n := range names {
+		if n == name {
+			return value
+		}
+	}
+
+	return -1
+}
+
+// SettingName returns the name of a Discord enum value, or the number
+// itself if it has no name
+func SettingName(names []string, value int) string {
+	if value >= 0 && value < len(names) {
+		return names[value]
+	}
+
+	return fmt.Sprint(value)
+}
+
+// validateSettings checks the server.yaml settings. An empty setting is
+// left as it is in Discord.
+func (s *ServerConfig) validateSettings() error {
+	settings := []struct {
+		name  string
+		value string
+		names []string
+	}{
+		{"verification_level", s.Settings.VerificationLevel, VerificationLevels},
+		{"default_notification_level", s.Settings.DefaultNotificationLevel, DefaultNotificationLevels},
+		{"explicit_content_filter", s.Settings.ExplicitContentFilter, ExplicitContentFilters},
+	}
+
+	for _, setting := range settings {
+		if setting.value != "" && SettingValue(setting.names, setting.value) < 0 {
+			return fmt.Errorf("server settings: invalid %s %q (want one of: %s)",
+				setting.name, setting.value, strings.Join(setting.names, ", "))
+		}
+	}
+
+	return nil
+}
+
+// validateSpecialChannels checks that every special channel is in channels.yaml
+// and of a type that can fill the role, and that community servers have the
+// channels Discord requires
+func (c *Config) validateSpecialChannels() error {
+	special := c.Server.Channels
+
+	assignments := []struct {
+		name  string
+		ref   string
+		types []string
+	}{
+		{"system", special.System, []string{"text"}},
+		{"rules", special.Rules, []string{"text", "announcement"}},
+		{"public_updates", special.PublicUpdates, []string{"text", "announcement"}},
+		{"afk", special.AFK, []string{"voice"}},
+	}
+	for _, a := range assignments {
+		if a.ref == "" {
+			continue
+		}
+		ch := c.Channels.FindChannel(a.ref)
+		if ch == nil {
+			return fmt.Errorf("server channels: %s channel %q is not in channels.yaml", a.name, a.ref)
+		}
+		if !slices.Contains(a.types, ch.Type) {
+			return fmt.Errorf("server channels: %s channel %q has type %s (want %s)",
+				a.name, a.ref, ch.Type, strings.Join(a.types, " or "))
+		}
+	}
+
+	if c.Server.Features.Community && (special.Rules == "" || special.PublicUpdates == "") {
+		return fmt.Errorf("server channels: community servers need a rules and a public_updates channel")
+	}
+
+	if special.AFKTimeout != 0 {
+		valid := false
+		for _, timeout := range AFKTimeouts {
+			valid = valid || timeout == special.AFKTimeout
+		}
+		if !valid {
+			return fmt.Errorf("server channels: invalid afk_timeout %d (want one of: 60, 300, 900, 1800, 3600)", special.AFKTimeout)
+		}
+	}
+
+	for _, flag := range special.SystemChannelFlags {
+		if SettingValue(SystemChannelFlags, flag) < 0 {
+			return fmt.Errorf("server channels: unknown system_channel_flags entry %q (want one of: %s)",
+				flag, strings.Join(SystemChannelFlags, ", "))
+		}
+	}
+
+	return nil
+}
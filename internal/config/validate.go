@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// identities tracks the keys and previous names claimed by every entry of
+// one kind. Each must be unique, otherwise matching against Discord would be
+// ambiguous.
+type identities struct {
+	kind  string
+	owner map[string]string
+}
+
+func newIdentities(kind string) *identities {
+	return &identities{kind: kind, owner: make(map[string]string)}
+}
+
+func (ids *identities) claim(key string, previousNames []string) error {
+	if other, taken := ids.owner[key]; taken {
+		return fmt.Errorf("%s %q is already used by %q", ids.kind, key, other)
+	}
+	ids.owner[key] = key
+
+	for _, name := range previousNames {
+		if other, taken := ids.owner[name]; taken {
+			return fmt.Errorf("%s %q previous name %q is already used by %q", ids.kind, key, name, other)
+		}
+		ids.owner[name] = key
+	}
+
+	return nil
+}
+
+func (c *Config) validate() error {
+	if c.Server.Pruning.Mode == "" {
+		c.Server.Pruning.Mode = PruneReport
+	}
+	if err := ValidatePruneMode(c.Server.Pruning.Mode); err != nil {
+		return err
+	}
+
+	if err := c.Server.validateSettings(); err != nil {
+		return err
+	}
+
+	if err := c.checkPermissionNames(); err != nil {
+		return err
+	}
+	if err := c.resolvePermissionSets(); err != nil {
+		return err
+	}
+
+	roles := newIdentities("role")
+	for _, role := range c.Roles.Roles {
+		if err := roles.claim(role.StateKey(), role.PreviousNames); err != nil {
+			return err
+		}
+	}
+
+	categories := newIdentities("category")
+	channels := newIdentities("channel")
+	for _, ch := range c.Channels.Channels {
+		if err := c.validateChannel(channels, ch); err != nil {
+			return err
+		}
+	}
+	for _, category := range c.Channels.Categories {
+		if err := categories.claim(category.StateKey(), category.PreviousNames); err != nil {
+			return err
+		}
+		if err := c.validateTargets(category.Name, category.Permissions); err != nil {
+			return err
+		}
+
+		for _, ch := range category.Channels {
+			if err := c.validateChannel(channels, ch); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.validateSpecialChannels(); err != nil {
+		return err
+	}
+
+	if gh := c.Integrations.GitHub; gh != nil && gh.Enabled {
+		if c.Channels.FindChannel(gh.TargetChannel) == nil {
+			return fmt.Errorf("github target_channel %q is not in channels.yaml", gh.TargetChannel)
+		}
+	}
+
+	return nil
+}
+
+// validateChannel checks a channel's identity, overwrite targets, type, and
+// settings
+func (c *Config) validateChannel(channels *identities, ch Channel) error {
+	if err := channels.claim(ch.StateKey(), ch.PreviousNames); err != nil {
+		return err
+	}
+	if err := c.validateTargets(ch.Name, ch.Permissions); err != nil {
+		return err
+	}
+	if err := validateChannelType(ch); err != nil {
+		return err
+	}
+	if err := validateChannelSettings(ch); err != nil {
+		return err
+	}
+
+	return validateForum(ch)
+}
+
+// validateTargets checks that every overwrite target is @everyone, a role
+// from roles.yaml, or a member's user ID
+func (c *Config) validateTargets(owner string, perms map[string]PermissionSet) error {
+	for target := range perms {
+		switch {
+		case target == EveryoneTarget:
+		case strings.HasPrefix(target, MemberTargetPrefix):
+			if !isSnowflake(strings.TrimPrefix(target, MemberTargetPrefix)) {
+				return fmt.Errorf("%s permissions: %q is not a valid user ID", owner, target)
+			}
+		case c.Roles.FindRole(target) == nil:
+			return fmt.Errorf("%s permissions: unknown role %q (use %q, a role from roles.yaml, or %s<id>)",
+				owner, target, EveryoneTarget, MemberTargetPrefix)
+		}
+	}
+
+	return nil
+}
+
+// isSnowflake reports whether s looks like a Discord ID
+func isSnowflake(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidatePruneMode checks a pruning mode from server.yaml or a flag
+func ValidatePruneMode(mode string) error {
+	switch mode {
+	case PruneOff, PruneReport, PruneDelete:
+		return nil
+	}
+
+	return fmt.Errorf("invalid pruning mode %q (want %s, %s, or %s)", mode, PruneOff, PruneReport, PruneDelete)
+}
@@ -0,0 +1,214 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File describes the layout of a config file: the comment block at the top
+// and the comments above its top-level sections
+type File struct {
+	Header   string
+	Sections map[string]string
+}
+
+// The config files, laid out as in config/, so files written by the bot
+// diff cleanly against the hand-written ones
+var (
+	ServerFile = File{
+		Header: "# WorkFort Discord Server Configuration",
+		Sections: map[string]string{
+			"settings": "Server settings, applied by setup and sync (leave one out to manage it by hand)",
+			"features": "Server features",
+			"channels": "Channels with a special purpose, by name or key from channels.yaml. Community\n" +
+				"servers need rules and public_updates. Leave one out to manage it by hand.",
+			"pruning": "What sync does with roles and channels that exist in Discord but not in config\n" +
+				"(override with --prune). @everyone and bot-managed roles are never touched.",
+		},
+	}
+
+	ChannelsFile = File{
+		Header: "# WorkFort Discord Channel Structure\n" +
+			"# Sync keeps the sidebar in this order: categories by position, and channels\n" +
+			"# by position within their category (file order breaks ties).\n" +
+			"# Channel types: text, voice, forum, announcement, stage, media.\n" +
+			"# A category's permissions are inherited by channels without their own, which\n" +
+			"# stay synced with it; a channel's permissions override the category's.\n" +
+			"# Channels outside any category go under a top-level `channels:` key.",
+	}
+
+	RolesFile = File{
+		Header: "# WorkFort Discord Roles\n" +
+			"# Listed highest first: sync arranges the role hierarchy in this order (set\n" +
+			"# `position: 1` etc. to override). Every role here must sit below the bot's\n" +
+			"# own role, otherwise plan and sync stop and name the roles it can't move.",
+	}
+
+	IntegrationsFile = File{
+		Header: "# WorkFort Discord Integrations",
+		Sections: map[string]string{
+			"github": "GitHub webhook for automated notifications",
+		},
+	}
+)
+
+// valueComments are the comments after settings whose allowed values are
+// listed in the hand-written files
+var valueComments = map[string]string{
+	"verification_level":         strings.Join(VerificationLevels, ", "),
+	"default_notification_level": strings.Join(DefaultNotificationLevels, ", "),
+	"explicit_content_filter":    strings.Join(ExplicitContentFilters, ", "),
+	"afk_timeout":                "seconds: " + strings.Trim(fmt.Sprint(AFKTimeouts), "[]"),
+	"sort_order":                 strings.Join(ForumSortOrders, ", "),
+	"layout":                     strings.Join(ForumLayouts, ", "),
+	"mode":                       strings.Join([]string{PruneOff, PruneReport, PruneDelete}, ", "),
+}
+
+// spacedLists are the lists whose entries are separated by a blank line in
+// the hand-written config files
+var spacedLists = map[string]bool{
+	"categories": true,
+	"channels":   true,
+	"roles":      true,
+}
+
+var (
+	keyLine  = regexp.MustCompile(`^( *)([a-z_]+):$`)
+	itemLine = regexp.MustCompile(`^( *)- `)
+
+	// longEscape matches an escaped backslash or a \U escape, so that a
+	// literal backslash followed by U is never mistaken for an escape
+	longEscape = regexp.MustCompile(`\\\\|\\U[0-9A-F]{8}`)
+)
+
+// Write saves v as a config file laid out like the hand-written ones: the
+// file's comments, two-space indentation, double-quoted string values, and
+// blank lines between sections and between the entries of the category,
+// channel, and role lists
+func Write(path string, file File, v interface{}) error {
+	var node yaml.Node
+	if err := node.Encode(v); err != nil {
+		return fmt.Errorf("encoding %s: %w", path, err)
+	}
+	quoteValues(&node)
+	comment(&node, file)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return fmt.Errorf("encoding %s: %w", path, err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("encoding %s: %w", path, err)
+	}
+
+	text := unescapeEmoji(buf.String())
+	for _, comment := range valueComments {
+		// Two spaces before a comment, as in the hand-written files
+		text = strings.ReplaceAll(text, " # "+comment+"\n", "  # "+comment+"\n")
+	}
+
+	data := file.Header + "\n\n" + spaceEntries(text)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// quoteValues double-quotes the string values of mappings, leaving keys and
+// list items such as permission names plain
+func quoteValues(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 1; i < len(node.Content); i += 2 {
+			if value := node.Content[i]; value.Kind == yaml.ScalarNode && value.Tag == "!!str" {
+				value.Style = yaml.DoubleQuotedStyle
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		quoteValues(child)
+	}
+}
+
+// comment adds the file's section comments, then the value comments
+func comment(node *yaml.Node, file File) {
+	for i := 0; i < len(node.Content); i += 2 {
+		if text, ok := file.Sections[node.Content[i].Value]; ok {
+			node.Content[i].HeadComment = text
+		}
+	}
+	commentValues(node)
+}
+
+// commentValues lists the allowed values after each setting that has them
+func commentValues(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 1; i < len(node.Content); i += 2 {
+			if text, ok := valueComments[node.Content[i-1].Value]; ok && node.Content[i].Kind == yaml.ScalarNode {
+				node.Content[i].LineComment = text
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		commentValues(child)
+	}
+}
+
+// unescapeEmoji writes out the characters the encoder escapes as \U in
+// double-quoted strings, which covers most emoji, as the hand-written files do
+func unescapeEmoji(text string) string {
+	return longEscape.ReplaceAllStringFunc(text, func(s string) string {
+		if s == `\\` {
+			return s
+		}
+		r, err := strconv.ParseUint(s[2:], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return s
+		}
+		return string(rune(r))
+	})
+}
+
+// spaceEntries adds a blank line before each top-level section, and before
+// every entry but the first of the lists named in spacedLists
+func spaceEntries(text string) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+
+	// keys[n] is the last key seen at indent n
+	keys := make(map[int]string)
+	for i, line := range lines {
+		m := keyLine.FindStringSubmatch(line)
+		if m != nil {
+			keys[len(m[1])] = m[2]
+		}
+
+		// Top-level sections, with the comments above them
+		section := (m != nil && m[1] == "") || strings.HasPrefix(line, "#")
+		if section && i > 0 && !strings.HasPrefix(lines[i-1], "#") {
+			out = append(out, "")
+		}
+
+		if m := itemLine.FindStringSubmatch(line); m != nil && i > 0 {
+			indent := len(m[1])
+			if spacedLists[keys[indent-2]] && !keyLine.MatchString(lines[i-1]) {
+				out = append(out, "")
+			}
+		}
+
+		out = append(out, line)
+	}
+
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,144 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Work-Fort/Discord/internal/backup"
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/Work-Fort/Discord/internal/state"
+	"github.com/bwmarrin/discordgo"
+)
+
+// files are the config files import writes, besides the state file
+var files = []string{"server.yaml", "channels.yaml", "roles.yaml", "integrations.yaml"}
+
+// githubEvents are the GitHub events a new integration subscribes to.
+// They're set on the GitHub side, so Discord can't tell us.
+var githubEvents = []string{"pull_request", "issues", "release"}
+
+// Run writes config files describing the live guild, so an existing server
+// can be brought under management: the first plan afterwards should show no
+// changes. Existing config files are only overwritten if force is set.
+func Run(cfg *config.Config, force bool) error {
+	if !force {
+		for _, name := range append(files, filepath.Base(state.Path)) {
+			path := filepath.Join(config.Dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+			}
+		}
+	}
+
+	session, err := discordgo.New("Bot " + cfg.BotToken)
+	if err != nil {
+		return fmt.Errorf("creating Discord session: %w", err)
+	}
+
+	if err := session.Open(); err != nil {
+		return fmt.Errorf("opening Discord connection: %w", err)
+	}
+	defer session.Close()
+
+	fmt.Println("Connected to Discord")
+	fmt.Println("Importing server state...")
+
+	live, err := reconcile.Fetch(session, cfg.GuildID)
+	if err != nil {
+		return fmt.Errorf("reading server state: %w", err)
+	}
+
+	// Re-importing keeps the keys of anything already tracked
+	tracked, err := state.Load(state.Path, cfg.GuildID)
+	if errors.Is(err, state.ErrOtherGuild) {
+		tracked = state.New(cfg.GuildID)
+	} else if err != nil {
+		return fmt.Errorf("loading state: %w", err)
+	}
+
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+
+	pruning := config.PruningConfig{Mode: config.PruneReport, Protected: []string{}}
+	snapshot, err := backup.Export(manageable(live), tracked, pruning, config.Dir)
+	if err != nil {
+		return err
+	}
+
+	if err := exportIntegrations(live, tracked, snapshot); err != nil {
+		return fmt.Errorf("exporting integrations: %w", err)
+	}
+
+	if err := snapshot.Save(state.Path); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+
+	// Catch anything Discord allows that config doesn't, such as two
+	// channels with the same name
+	if _, err := config.Load(); err != nil {
+		fmt.Printf("  ⚠ The imported config needs editing before use: %v\n", err)
+	}
+
+	fmt.Printf("✓ Config written to: %s\n", config.Dir)
+	fmt.Println("  Run plan to confirm it matches the server")
+
+	return nil
+}
+
+// manageable returns the live guild without the roles at or above the bot's
+// highest role, which the bot can't manage, so plan would only warn about them
+func manageable(live *reconcile.Live) *reconcile.Live {
+	top := live.BotTopRole()
+	if top == nil {
+		return live
+	}
+
+	kept := *live
+	kept.Roles = nil
+	for _, role := range live.Roles {
+		if role.Position >= top.Position && role.ID != live.GuildID && !role.Managed {
+			fmt.Printf("  ⚠ Skipping role at or above the bot's own: %s\n", role.Name)
+			continue
+		}
+		kept.Roles = append(kept.Roles, role)
+	}
+
+	return &kept
+}
+
+// exportIntegrations writes integrations.yaml, enabling the GitHub
+// integration if the server has its webhook
+func exportIntegrations(live *reconcile.Live, tracked, snapshot *state.State) error {
+	github := &config.GitHubIntegration{Events: githubEvents}
+
+	if webhook := reconcile.GitHubWebhook(live, tracked); webhook != nil {
+		for key, id := range snapshot.Channels {
+			if id == webhook.ChannelID {
+				github.Enabled = true
+				github.TargetChannel = key
+			}
+		}
+		if github.Enabled {
+			snapshot.Webhooks[reconcile.GitHubWebhookKey] = webhook.ID
+		} else {
+			fmt.Printf("  ⚠ GitHub webhook is in a channel that wasn't exported, left disabled: %s\n", webhook.ChannelID)
+		}
+	}
+
+	integrations := config.IntegrationsConfig{GitHub: github}
+	if err := config.Write(filepath.Join(config.Dir, "integrations.yaml"), config.IntegrationsFile, integrations); err != nil {
+		return err
+	}
+
+	if github.Enabled {
+		fmt.Printf("  ✓ Exported integrations (GitHub → %s)\n", github.TargetChannel)
+	} else {
+		fmt.Println("  ✓ Exported integrations (none)")
+	}
+
+	return nil
+}
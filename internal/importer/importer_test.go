@@ -0,0 +1,87 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Work-Fort/Discord/internal/backup"
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/Work-Fort/Discord/internal/state"
+	"github.com/bwmarrin/discordgo"
+)
+
+const guildID = "1"
+
+// testLive returns a guild with a role above the bot's, as servers often
+// have for their owners
+func testLive() *reconcile.Live {
+	return &reconcile.Live{
+		GuildID: guildID,
+		Guild:   &discordgo.Guild{Name: "Test"},
+		Roles: []*discordgo.Role{
+			{ID: guildID, Name: "@everyone"},
+			{ID: "20", Name: "Member", Position: 1, Permissions: discordgo.PermissionSendMessages},
+			{ID: "22", Name: "Bot", Position: 2, Managed: true},
+			{ID: "21", Name: "Owner", Position: 3, Permissions: discordgo.PermissionAdministrator},
+		},
+		Channels: []*discordgo.Channel{
+			{ID: "100", Name: "COMMUNITY", Type: discordgo.ChannelTypeGuildCategory},
+			{ID: "200", Name: "general", ParentID: "100", Type: discordgo.ChannelTypeGuildText},
+		},
+		Extras:     map[string]reconcile.ChannelExtra{},
+		BotRoleIDs: []string{"22"},
+	}
+}
+
+func TestManageable(t *testing.T) {
+	live := testLive()
+	kept := manageable(live)
+
+	var names []string
+	for _, role := range kept.Roles {
+		names = append(names, role.Name)
+	}
+	if got := strings.Join(names, ", "); got != "@everyone, Member, Bot" {
+		t.Errorf("kept roles %s", got)
+	}
+	if len(live.Roles) != 4 {
+		t.Error("manageable changed the live guild")
+	}
+
+	// Without the bot's roles nothing can be ruled out
+	live.BotRoleIDs = nil
+	if kept := manageable(live); len(kept.Roles) != 4 {
+		t.Errorf("kept %d roles, want all 4", len(kept.Roles))
+	}
+}
+
+// TestRoundTrip checks that the first plan after an import shows no
+// changes, even though the guild has a role the bot can't manage
+func TestRoundTrip(t *testing.T) {
+	t.Setenv("DISCORD_BOT_TOKEN", "token")
+	t.Setenv("DISCORD_GUILD_ID", guildID)
+
+	live := testLive()
+	dir := t.TempDir()
+	pruning := config.PruningConfig{Mode: config.PruneReport, Protected: []string{}}
+	snapshot, err := backup.Export(manageable(live), state.New(guildID), pruning, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := reconcile.Build(cfg, live, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Empty() {
+		var out strings.Builder
+		p.Print(&out)
+		t.Errorf("plan after an import isn't empty:\n%s", out.String())
+	}
+}
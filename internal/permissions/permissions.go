@@ -0,0 +1,109 @@
+package permissions
+
+import (
+	"sort"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// permission pairs a config name with its Discord bit
+type permission struct {
+	name string
+	bit  int64
+}
+
+// all lists every permission discordgo exposes, in bit order, by the
+// snake_case name Discord uses in its API docs. Each bit has exactly one
+// name. read_messages is the old name for view_channel and is not accepted.
+var all = []permission{
+	{"create_instant_invite", discordgo.PermissionCreateInstantInvite},
+	{"kick_members", discordgo.PermissionKickMembers},
+	{"ban_members", discordgo.PermissionBanMembers},
+	{"administrator", discordgo.PermissionAdministrator},
+	{"manage_channels", discordgo.PermissionManageChannels},
+	{"manage_guild", discordgo.PermissionManageServer},
+	{"add_reactions", discordgo.PermissionAddReactions},
+	{"view_audit_log", discordgo.PermissionViewAuditLogs},
+	{"priority_speaker", discordgo.PermissionVoicePrioritySpeaker},
+	{"stream", discordgo.PermissionVoiceStreamVideo},
+	{"view_channel", discordgo.PermissionViewChannel},
+	{"send_messages", discordgo.PermissionSendMessages},
+	{"send_tts_messages", discordgo.PermissionSendTTSMessages},
+	{"manage_messages", discordgo.PermissionManageMessages},
+	{"embed_links", discordgo.PermissionEmbedLinks},
+	{"attach_files", discordgo.PermissionAttachFiles},
+	{"read_message_history", discordgo.PermissionReadMessageHistory},
+	{"mention_everyone", discordgo.PermissionMentionEveryone},
+	{"use_external_emojis", discordgo.PermissionUseExternalEmojis},
+	{"view_guild_insights", discordgo.PermissionViewGuildInsights},
+	{"connect", discordgo.PermissionVoiceConnect},
+	{"speak", discordgo.PermissionVoiceSpeak},
+	{"mute_members", discordgo.PermissionVoiceMuteMembers},
+	{"deafen_members", discordgo.PermissionVoiceDeafenMembers},
+	{"move_members", discordgo.PermissionVoiceMoveMembers},
+	{"use_vad", discordgo.PermissionVoiceUseVAD},
+	{"change_nickname", discordgo.PermissionChangeNickname},
+	{"manage_nicknames", discordgo.PermissionManageNicknames},
+	{"manage_roles", discordgo.PermissionManageRoles},
+	{"manage_webhooks", discordgo.PermissionManageWebhooks},
+	{"manage_emojis_and_stickers", discordgo.PermissionManageEmojis},
+	{"use_application_commands", discordgo.PermissionUseSlashCommands},
+	{"request_to_speak", discordgo.PermissionVoiceRequestToSpeak},
+	{"manage_events", discordgo.PermissionManageEvents},
+	{"manage_threads", discordgo.PermissionManageThreads},
+	{"create_public_threads", discordgo.PermissionCreatePublicThreads},
+	{"create_private_threads", discordgo.PermissionCreatePrivateThreads},
+	{"use_external_stickers", discordgo.PermissionUseExternalStickers},
+	{"send_messages_in_threads", discordgo.PermissionSendMessagesInThreads},
+	{"use_embedded_activities", discordgo.PermissionUseActivities},
+	{"moderate_members", discordgo.PermissionModerateMembers},
+}
+
+// byName maps the permission names used in config files to Discord bits
+var byName = func() map[string]int64 {
+	m := make(map[string]int64, len(all))
+	for _, p := range all {
+		m[p.name] = p.bit
+	}
+	return m
+}()
+
+// Lookup returns the permission bit for a config permission name and
+// whether the name is known
+func Lookup(name string) (int64, bool) {
+	bit, ok := byName[name]
+	return bit, ok
+}
+
+// Value returns the permission bit for a config permission name. Config
+// files are validated at load time, so unknown names never reach here; they
+// yield 0.
+func Value(name string) int64 {
+	return byName[name]
+}
+
+// Bits combines a list of permission names into a single bitfield
+func Bits(names []string) int64 {
+	bits := int64(0)
+	for _, name := range names {
+		bits |= Value(name)
+	}
+
+	return bits
+}
+
+// Names is the inverse of Bits: it returns the names of the permissions set
+// in a bitfield, sorted, along with any set bits that have no known name.
+// Callers report unknown bits rather than dropping them silently.
+func Names(bits int64) (names []string, unknown int64) {
+	unknown = bits
+	for _, p := range all {
+		if bits&p.bit != 0 {
+			names = append(names, p.name)
+			unknown &^= p.bit
+		}
+	}
+	sort.Strings(names)
+
+	return names, unknown
+}
@@ -0,0 +1,55 @@
+package permissions
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestLookup(t *testing.T) {
+	if bit, ok := Lookup("view_channel"); !ok || bit != discordgo.PermissionViewChannel {
+		t.Errorf("view_channel is %d, %v", bit, ok)
+	}
+
+	// read_messages is the old name for view_channel
+	for _, name := range []string{"read_messages", "View_Channel", ""} {
+		if _, ok := Lookup(name); ok {
+			t.Errorf("%q is accepted", name)
+		}
+	}
+}
+
+func TestOneNamePerBit(t *testing.T) {
+	seen := make(map[int64]string)
+	for _, p := range all {
+		if other, ok := seen[p.bit]; ok {
+			t.Errorf("%s and %s share a bit", other, p.name)
+		}
+		seen[p.bit] = p.name
+	}
+}
+
+func TestBits(t *testing.T) {
+	got := Bits([]string{"send_messages", "add_reactions", "send_messages"})
+	if want := discordgo.PermissionSendMessages | discordgo.PermissionAddReactions; got != int64(want) {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestNames(t *testing.T) {
+	names, unknown := Names(discordgo.PermissionViewChannel | discordgo.PermissionAddReactions | 1<<60)
+	if want := []string{"add_reactions", "view_channel"}; !slices.Equal(names, want) {
+		t.Errorf("names %v, want %v", names, want)
+	}
+	if unknown != 1<<60 {
+		t.Errorf("unknown 0x%x, want 0x%x", unknown, int64(1<<60))
+	}
+
+	// Every name decodes back to itself
+	for _, p := range all {
+		if names, unknown := Names(Bits([]string{p.name})); !slices.Equal(names, []string{p.name}) || unknown != 0 {
+			t.Errorf("%s decodes as %v, 0x%x", p.name, names, unknown)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package plan
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/Work-Fort/Discord/internal/state"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Run previews the changes sync would make without modifying the server.
+// It reports whether any changes are pending.
+func Run(cfg *config.Config) (bool, error) {
+	session, err := discordgo.New("Bot " + cfg.BotToken)
+	if err != nil {
+		return false, fmt.Errorf("creating Discord session: %w", err)
+	}
+
+	if err := session.Open(); err != nil {
+		return false, fmt.Errorf("opening Discord connection: %w", err)
+	}
+	defer session.Close()
+
+	fmt.Println("Connected to Discord")
+	fmt.Println("Planning changes...")
+
+	live, err := reconcile.Fetch(session, cfg.GuildID)
+	if err != nil {
+		return false, fmt.Errorf("reading server state: %w", err)
+	}
+
+	st, err := state.Load(state.Path, cfg.GuildID)
+	if err != nil {
+		return false, fmt.Errorf("loading state: %w", err)
+	}
+
+	p, err := reconcile.Build(cfg, live, st)
+	if err != nil {
+		return false, fmt.Errorf("building plan: %w", err)
+	}
+	p.PrintWarnings(os.Stdout)
+
+	if p.Empty() {
+		fmt.Println("  ⊙ No changes, server matches config")
+		p.PrintPrune(os.Stdout)
+		return false, nil
+	}
+
+	p.Print(os.Stdout)
+	fmt.Println()
+	fmt.Printf("Plan: %s\n", p.Summary())
+
+	return true, nil
+}
@@ -0,0 +1,400 @@
+package reconcile
+
+import (
+	"fmt"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/bwmarrin/discordgo"
+)
+
+// diffChannels adds category, channel, and overwrite creates and updates to
+// the plan and returns the deletes for live channels and categories that are
+// not in config. Channel deletes come before category deletes.
+func diffChannels(p *Plan, cfg *config.Config, live *Live) []*Change {
+	categories, channels, matched := matchChannels(p, cfg, live)
+
+	for _, ch := range cfg.Channels.Channels {
+		diffChannel(p, live, nil, nil, ch, channels)
+	}
+
+	for _, category := range cfg.Channels.Categories {
+		parent, ok := categories[category.StateKey()]
+		if ok {
+			p.ids.categories[category.StateKey()] = parent.ID
+			if parent.Name != category.Name {
+				p.add(renameCategory(category, parent))
+			}
+			for _, change := range diffOverwrites(p, live, category.Name, category.Permissions, parent) {
+				p.add(change)
+			}
+		} else {
+			p.add(createCategory(p, category))
+		}
+
+		for _, ch := range category.Channels {
+			diffChannel(p, live, &category, parent, ch, channels)
+		}
+	}
+
+	if change := diffOrder(p, cfg, live, categories, channels); change != nil {
+		p.add(change)
+	}
+
+	var deletes []*Change
+	for _, ch := range live.channels() {
+		if !matched[ch.ID] {
+			deletes = append(deletes, deleteChannel(KindChannel, ch))
+		}
+	}
+	for _, ch := range live.categories() {
+		if !matched[ch.ID] {
+			deletes = append(deletes, deleteChannel(KindCategory, ch))
+		}
+	}
+
+	return deletes
+}
+
+// diffChannel adds the create for a config channel, or the updates and
+// overwrite changes for its matched live channel. category and parent are
+// nil for a channel outside any category.
+func diffChannel(p *Plan, live *Live, category *config.Category, parent *discordgo.Channel, ch config.Channel, channels map[string]*discordgo.Channel) {
+	existing, ok := channels[ch.StateKey()]
+	if !ok {
+		p.add(createChannel(p, category, ch))
+		return
+	}
+
+	p.ids.channels[ch.StateKey()] = existing.ID
+
+	if change := updateChannel(p, live, ch, existing); change != nil {
+		p.add(change)
+	}
+
+	// A channel that inherits its category's permissions is kept synced as
+	// a whole; one with its own is diffed per overwrite
+	var overwrites []*Change
+	switch {
+	case category == nil:
+		overwrites = diffOverwrites(p, live, ch.Name, ch.Permissions, existing)
+	case ch.Permissions == nil:
+		if change := diffSync(p, live, *category, ch, existing, parent); change != nil {
+			overwrites = append(overwrites, change)
+		}
+	default:
+		overwrites = diffOverwrites(p, live, ch.Name, category.EffectivePermissions(ch), existing)
+	}
+	for _, change := range overwrites {
+		p.add(change)
+	}
+}
+
+// matchChannels pairs config categories and channels (by state key) with
+// live ones and returns both sets of pairs along with the set of matched
+// live IDs. IDs recorded in state are tried first so that renames, whether
+// made in Discord or in config, become in-place edits rather than a delete
+// and a create. Anything left over is matched by name and then by previous
+// names: categories by name alone, channels by name and type, preferring one
+// already inside the expected category.
+func matchChannels(p *Plan, cfg *config.Config, live *Live) (map[string]*discordgo.Channel, map[string]*discordgo.Channel, map[string]bool) {
+	liveCategories := live.categories()
+	liveChannels := live.channels()
+
+	categories := make(map[string]*discordgo.Channel)
+	channels := make(map[string]*discordgo.Channel)
+	matched := make(map[string]bool)
+
+	for _, category := range cfg.Channels.Categories {
+		if existing := findByID(liveCategories, matched, trackedIDs(p.state.Categories, category.StateKey(), category.PreviousNames), discordgo.ChannelTypeGuildCategory); existing != nil {
+			categories[category.StateKey()] = existing
+			matched[existing.ID] = true
+		}
+	}
+	for _, ch := range allChannels(cfg) {
+		if existing := findByID(liveChannels, matched, trackedIDs(p.state.Channels, ch.StateKey(), ch.PreviousNames), channelType(ch.Type)); existing != nil {
+			channels[ch.StateKey()] = existing
+			matched[existing.ID] = true
+		}
+	}
+
+	for _, category := range cfg.Channels.Categories {
+		if _, ok := categories[category.StateKey()]; ok {
+			continue
+		}
+		if existing := findCategory(liveCategories, matched, category); existing != nil {
+			categories[category.StateKey()] = existing
+			matched[existing.ID] = true
+		}
+	}
+
+	match := func(ch config.Channel, parentID string) {
+		if _, ok := channels[ch.StateKey()]; ok {
+			return
+		}
+		if existing := findChannel(liveChannels, matched, ch, parentID); existing != nil {
+			channels[ch.StateKey()] = existing
+			matched[existing.ID] = true
+		}
+	}
+	for _, ch := range cfg.Channels.Channels {
+		match(ch, "")
+	}
+	for _, category := range cfg.Channels.Categories {
+		parentID := ""
+		if existing, ok := categories[category.StateKey()]; ok {
+			parentID = existing.ID
+		}
+		for _, ch := range category.Channels {
+			match(ch, parentID)
+		}
+	}
+
+	return categories, channels, matched
+}
+
+// findByID returns the first unmatched live channel with one of the given
+// IDs and the given type
+func findByID(channels []*discordgo.Channel, matched map[string]bool, ids []string, typ discordgo.ChannelType) *discordgo.Channel {
+	for _, id := range ids {
+		for _, ch := range channels {
+			if ch.ID == id && ch.Type == typ && !matched[ch.ID] {
+				return ch
+			}
+		}
+	}
+
+	return nil
+}
+
+// findCategory returns the first unmatched live category with the
+// category's name, or failing that one of its previous names
+func findCategory(categories []*discordgo.Channel, matched map[string]bool, category config.Category) *discordgo.Channel {
+	for _, name := range names(category.Name, category.PreviousNames) {
+		for _, ch := range categories {
+			if !matched[ch.ID] && ch.Name == name {
+				return ch
+			}
+		}
+	}
+
+	return nil
+}
+
+// findChannel returns the unmatched live channel with the same name (or a
+// previous name) and type as ch, preferring one already inside the
+// expected parent category, or outside any category if parentID is empty
+func findChannel(channels []*discordgo.Channel, matched map[string]bool, ch config.Channel, parentID string) *discordgo.Channel {
+	for _, name := range names(ch.Name, ch.PreviousNames) {
+		var fallback *discordgo.Channel
+		for _, candidate := range channels {
+			if matched[candidate.ID] || candidate.Name != name || candidate.Type != channelType(ch.Type) {
+				continue
+			}
+			if candidate.ParentID == parentID {
+				return candidate
+			}
+			if fallback == nil {
+				fallback = candidate
+			}
+		}
+		if fallback != nil {
+			return fallback
+		}
+	}
+
+	return nil
+}
+
+func createCategory(p *Plan, category config.Category) *Change {
+	return &Change{
+		Action: ActionCreate,
+		Kind:   KindCategory,
+		Name:   category.Name,
+		apply: func(session *discordgo.Session) error {
+			created, err := session.GuildChannelCreateComplex(p.guildID, discordgo.GuildChannelCreateData{
+				Name:                 category.Name,
+				Type:                 discordgo.ChannelTypeGuildCategory,
+				Position:             category.Position,
+				PermissionOverwrites: p.resolveOverwrites(desiredOverwrites(category.Permissions)),
+			})
+			if err != nil {
+				return err
+			}
+			p.ids.categories[category.StateKey()] = created.ID
+			return nil
+		},
+	}
+}
+
+func renameCategory(category config.Category, existing *discordgo.Channel) *Change {
+	channelID := existing.ID
+	return &Change{
+		Action: ActionUpdate,
+		Kind:   KindCategory,
+		Name:   category.Name,
+		Fields: []Field{{Name: "name", Old: existing.Name, New: category.Name}},
+		apply: func(session *discordgo.Session) error {
+			return editChannel(session, channelID, map[string]interface{}{"name": category.Name})
+		},
+	}
+}
+
+// createChannel creates a channel inside category, or outside any category
+// if category is nil
+func createChannel(p *Plan, category *config.Category, ch config.Channel) *Change {
+	fields := []Field{{Name: "type", New: ch.Type}}
+	if category != nil {
+		fields = append(fields, Field{Name: "category", New: category.Name})
+	}
+	if config.HasTopic(ch.Type) && ch.Topic != "" {
+		fields = append(fields, Field{Name: "topic", New: quote(ch.Topic)})
+	}
+	fields = append(fields, settingFields(ch)...)
+	for _, tag := range desiredTags(ch, nil) {
+		fields = append(fields, Field{Name: "tag", New: formatTag(tag)})
+	}
+	for _, f := range []Field{
+		{Name: "default_reaction", New: ch.DefaultReaction},
+		{Name: "sort_order", New: ch.SortOrder},
+		{Name: "layout", New: ch.Layout},
+	} {
+		if f.New != "" {
+			fields = append(fields, f)
+		}
+	}
+	if ch.RequireTag {
+		fields = append(fields, Field{Name: "require_tag", New: "true"})
+	}
+
+	return &Change{
+		Action: ActionCreate,
+		Kind:   KindChannel,
+		Name:   ch.Name,
+		Fields: fields,
+		apply: func(session *discordgo.Session) error {
+			// Without explicit overwrites, Discord creates the channel
+			// synced with its category
+			data := discordgo.GuildChannelCreateData{
+				Name:     ch.Name,
+				Type:     channelType(ch.Type),
+				Position: ch.Position,
+			}
+			switch {
+			case category == nil:
+				data.PermissionOverwrites = p.resolveOverwrites(desiredOverwrites(ch.Permissions))
+			case ch.Permissions != nil:
+				data.PermissionOverwrites = p.resolveOverwrites(desiredOverwrites(category.EffectivePermissions(ch)))
+			}
+			if category != nil {
+				data.ParentID = p.ids.categories[category.StateKey()]
+			}
+			if config.HasTopic(ch.Type) {
+				data.Topic = ch.Topic
+			}
+			extra := createSettings(ch, &data)
+
+			created, err := session.GuildChannelCreateComplex(p.guildID, data)
+			if err != nil {
+				return err
+			}
+			p.ids.channels[ch.StateKey()] = created.ID
+
+			// Forum settings and some others can't be given at creation
+			if config.HasTags(ch.Type) {
+				for key, value := range forumData(ch) {
+					extra[key] = value
+				}
+				return editForum(session, p, ch, created.ID, extra)
+			}
+			if len(extra) > 0 {
+				return editChannel(session, created.ID, extra)
+			}
+			return nil
+		},
+	}
+}
+
+// updateChannel returns an update for the attributes of a matched channel
+// (including its settings, and forum tags) that differ from config, or nil
+// if it is already in sync. Moves between categories are handled by the
+// channel order change.
+func updateChannel(p *Plan, live *Live, ch config.Channel, existing *discordgo.Channel) *Change {
+	var fields []Field
+	data := make(map[string]interface{})
+
+	if existing.Name != ch.Name {
+		fields = append(fields, Field{Name: "name", Old: existing.Name, New: ch.Name})
+		data["name"] = ch.Name
+	}
+
+	if config.HasTopic(ch.Type) && existing.Topic != ch.Topic {
+		fields = append(fields, Field{Name: "topic", Old: quote(existing.Topic), New: quote(ch.Topic)})
+		data["topic"] = ch.Topic
+	}
+
+	fields = diffSettings(ch, existing, live.Extras[existing.ID], fields, data)
+	if config.HasTags(ch.Type) {
+		fields = diffForum(p, ch, existing, fields, data)
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	channelID := existing.ID
+	return &Change{
+		Action: ActionUpdate,
+		Kind:   KindChannel,
+		Name:   ch.Name,
+		Fields: fields,
+		apply: func(session *discordgo.Session) error {
+			if _, ok := data["available_tags"]; ok {
+				return editForum(session, p, ch, channelID, data)
+			}
+			return editChannel(session, channelID, data)
+		},
+	}
+}
+
+func deleteChannel(kind Kind, ch *discordgo.Channel) *Change {
+	channelID := ch.ID
+	return &Change{
+		Action: ActionDelete,
+		Kind:   kind,
+		Name:   ch.Name,
+		id:     channelID,
+		apply: func(session *discordgo.Session) error {
+			_, err := session.ChannelDelete(channelID)
+			return err
+		},
+	}
+}
+
+// editChannel sends a partial channel update. discordgo's ChannelEdit omits
+// zero values, which makes it impossible to clear a topic, so the payload is
+// built from only the fields that changed.
+func editChannel(session *discordgo.Session, channelID string, data map[string]interface{}) error {
+	_, err := session.RequestWithBucketID("PATCH", discordgo.EndpointChannel(channelID), data, discordgo.EndpointChannel(channelID))
+	return err
+}
+
+// allChannels returns every config channel, uncategorized ones first
+func allChannels(cfg *config.Config) []config.Channel {
+	all := append([]config.Channel(nil), cfg.Channels.Channels...)
+	for _, category := range cfg.Channels.Categories {
+		all = append(all, category.Channels...)
+	}
+
+	return all
+}
+
+// channelType returns the Discord type of a config channel type, which
+// validation has already checked
+func channelType(name string) discordgo.ChannelType {
+	typ, _ := config.ChannelType(name)
+	return typ
+}
+
+func quote(s string) string {
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,256 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/bwmarrin/discordgo"
+)
+
+// tagKey is the state key of a forum tag, scoped to its channel
+func tagKey(ch config.Channel, name string) string {
+	return ch.StateKey() + "/" + name
+}
+
+// matchTags pairs a forum's config tags (by state key) with its live tags,
+// first by the IDs recorded in state, then by name, then by previous names.
+// Matching by ID means a renamed tag is edited in place, so posts keep it.
+func matchTags(p *Plan, ch config.Channel, existing *discordgo.Channel) map[string]discordgo.ForumTag {
+	matches := make(map[string]discordgo.ForumTag)
+	matched := make(map[string]bool)
+
+	for _, tag := range ch.Tags {
+		var previous []string
+		for _, name := range tag.PreviousNames {
+			previous = append(previous, tagKey(ch, name))
+		}
+		for _, id := range trackedIDs(p.state.Tags, tagKey(ch, tag.StateKey()), previous) {
+			if live, ok := findTag(existing.AvailableTags, matched, func(t discordgo.ForumTag) bool { return t.ID == id }); ok {
+				matches[tag.StateKey()] = live
+				matched[live.ID] = true
+				break
+			}
+		}
+	}
+
+	for _, tag := range ch.Tags {
+		if _, ok := matches[tag.StateKey()]; ok {
+			continue
+		}
+		for _, name := range names(tag.Name, tag.PreviousNames) {
+			if live, ok := findTag(existing.AvailableTags, matched, func(t discordgo.ForumTag) bool { return t.Name == name }); ok {
+				matches[tag.StateKey()] = live
+				matched[live.ID] = true
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+func findTag(tags []discordgo.ForumTag, matched map[string]bool, match func(discordgo.ForumTag) bool) (discordgo.ForumTag, bool) {
+	for _, tag := range tags {
+		if !matched[tag.ID] && match(tag) {
+			return tag, true
+		}
+	}
+
+	return discordgo.ForumTag{}, false
+}
+
+// desiredTags returns a forum's tags as Discord expects them, carrying over
+// the IDs of matched live tags. Tags without an ID are created.
+func desiredTags(ch config.Channel, matches map[string]discordgo.ForumTag) []discordgo.ForumTag {
+	tags := make([]discordgo.ForumTag, 0, len(ch.Tags))
+	for _, tag := range ch.Tags {
+		emojiID, emojiName := config.ParseEmoji(tag.Emoji)
+		tags = append(tags, discordgo.ForumTag{
+			ID:        matches[tag.StateKey()].ID,
+			Name:      tag.Name,
+			Moderated: tag.Moderated,
+			EmojiID:   emojiID,
+			EmojiName: emojiName,
+		})
+	}
+
+	return tags
+}
+
+// diffForum adds the forum settings of a matched channel that differ from
+// config to an update's fields and payload. Tag IDs that are already known
+// are recorded in the plan.
+func diffForum(p *Plan, ch config.Channel, existing *discordgo.Channel, fields []Field, data map[string]interface{}) []Field {
+	matches := matchTags(p, ch, existing)
+	for key, tag := range matches {
+		p.ids.tags[tagKey(ch, key)] = tag.ID
+	}
+
+	want := desiredTags(ch, matches)
+	if tagFields := diffTags(existing.AvailableTags, want); len(tagFields) > 0 {
+		fields = append(fields, tagFields...)
+		data["available_tags"] = want
+	}
+
+	if ch.DefaultReaction != "" {
+		emojiID, emojiName := config.ParseEmoji(ch.DefaultReaction)
+		have := existing.DefaultReactionEmoji
+		if have.EmojiID != emojiID || have.EmojiName != emojiName {
+			fields = append(fields, Field{Name: "default_reaction", Old: formatEmoji(have.EmojiID, have.EmojiName), New: ch.DefaultReaction})
+			data["default_reaction_emoji"] = discordgo.ForumDefaultReaction{EmojiID: emojiID, EmojiName: emojiName}
+		}
+	}
+
+	if ch.SortOrder != "" {
+		want := config.SettingValue(config.ForumSortOrders, ch.SortOrder)
+		have := -1
+		if existing.DefaultSortOrder != nil {
+			have = int(*existing.DefaultSortOrder)
+		}
+		if have != want {
+			old := "not set"
+			if have >= 0 {
+				old = config.SettingName(config.ForumSortOrders, have)
+			}
+			fields = append(fields, Field{Name: "sort_order", Old: old, New: ch.SortOrder})
+			data["default_sort_order"] = want
+		}
+	}
+
+	if ch.Layout != "" {
+		want := config.SettingValue(config.ForumLayouts, ch.Layout)
+		if have := int(existing.DefaultForumLayout); have != want {
+			fields = append(fields, Field{Name: "layout", Old: config.SettingName(config.ForumLayouts, have), New: ch.Layout})
+			data["default_forum_layout"] = want
+		}
+	}
+
+	if have := existing.Flags&discordgo.ChannelFlagRequireTag != 0; have != ch.RequireTag {
+		fields = append(fields, Field{Name: "require_tag", Old: fmt.Sprint(have), New: fmt.Sprint(ch.RequireTag)})
+		data["flags"] = forumFlags(existing.Flags, ch.RequireTag)
+	}
+
+	return fields
+}
+
+// diffTags returns one field per tag that is added, changed, or removed, or
+// a single order field if only the order differs
+func diffTags(have, want []discordgo.ForumTag) []Field {
+	byID := make(map[string]discordgo.ForumTag)
+	for _, tag := range have {
+		byID[tag.ID] = tag
+	}
+
+	var fields []Field
+	kept := make(map[string]bool)
+	for _, tag := range want {
+		live, ok := byID[tag.ID]
+		switch {
+		case !ok:
+			fields = append(fields, Field{Name: "tag", Old: "none", New: formatTag(tag)})
+		case formatTag(live) != formatTag(tag):
+			fields = append(fields, Field{Name: "tag", Old: formatTag(live), New: formatTag(tag)})
+		}
+		kept[tag.ID] = true
+	}
+	for _, tag := range have {
+		if !kept[tag.ID] {
+			fields = append(fields, Field{Name: "tag", Old: formatTag(tag), New: "removed"})
+		}
+	}
+
+	if len(fields) == 0 && tagNames(have) != tagNames(want) {
+		fields = append(fields, Field{Name: "tag order", Old: tagNames(have), New: tagNames(want)})
+	}
+
+	return fields
+}
+
+// forumData returns the full forum settings payload for a new forum, which
+// discordgo can't set at creation
+func forumData(ch config.Channel) map[string]interface{} {
+	data := map[string]interface{}{
+		"available_tags": desiredTags(ch, nil),
+		"flags":          forumFlags(0, ch.RequireTag),
+	}
+	if ch.DefaultReaction != "" {
+		emojiID, emojiName := config.ParseEmoji(ch.DefaultReaction)
+		data["default_reaction_emoji"] = discordgo.ForumDefaultReaction{EmojiID: emojiID, EmojiName: emojiName}
+	}
+	if ch.SortOrder != "" {
+		data["default_sort_order"] = config.SettingValue(config.ForumSortOrders, ch.SortOrder)
+	}
+	if ch.Layout != "" {
+		data["default_forum_layout"] = config.SettingValue(config.ForumLayouts, ch.Layout)
+	}
+
+	return data
+}
+
+// editForum sends a partial forum update and records the IDs Discord gave
+// the forum's tags, including newly created ones
+func editForum(session *discordgo.Session, p *Plan, ch config.Channel, channelID string, data map[string]interface{}) error {
+	body, err := session.RequestWithBucketID("PATCH", discordgo.EndpointChannel(channelID), data, discordgo.EndpointChannel(channelID))
+	if err != nil {
+		return err
+	}
+
+	var updated discordgo.Channel
+	if err := json.Unmarshal(body, &updated); err != nil {
+		return fmt.Errorf("reading updated forum: %w", err)
+	}
+
+	byName := make(map[string]string)
+	for _, tag := range updated.AvailableTags {
+		byName[tag.Name] = tag.ID
+	}
+	for _, tag := range ch.Tags {
+		if id, ok := byName[tag.Name]; ok {
+			p.ids.tags[tagKey(ch, tag.StateKey())] = id
+		}
+	}
+
+	return nil
+}
+
+// forumFlags sets or clears the require-tag flag, keeping any other flags
+func forumFlags(flags discordgo.ChannelFlags, requireTag bool) discordgo.ChannelFlags {
+	if requireTag {
+		return flags | discordgo.ChannelFlagRequireTag
+	}
+	return flags &^ discordgo.ChannelFlagRequireTag
+}
+
+func formatTag(tag discordgo.ForumTag) string {
+	s := tag.Name
+	if emoji := formatEmoji(tag.EmojiID, tag.EmojiName); emoji != "none" {
+		s = emoji + " " + s
+	}
+	if tag.Moderated {
+		s += " (moderated)"
+	}
+
+	return s
+}
+
+func formatEmoji(id, name string) string {
+	switch {
+	case name != "":
+		return name
+	case id != "":
+		return id
+	default:
+		return "none"
+	}
+}
+
+func tagNames(tags []discordgo.ForumTag) string {
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,172 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Live is a snapshot of the guild resources that reconcile manages
+type Live struct {
+	GuildID  string
+	Guild    *discordgo.Guild
+	Roles    []*discordgo.Role
+	Channels []*discordgo.Channel
+	Webhooks []*discordgo.Webhook
+
+	// Extras holds the channel fields discordgo doesn't decode, by channel ID
+	Extras map[string]ChannelExtra
+
+	// BotRoleIDs are the roles held by the bot, which cap the roles it can
+	// manage. Nil if unknown, in which case the hierarchy is not checked.
+	BotRoleIDs []string
+}
+
+// Fetch reads the current settings, roles, channels, and webhooks of a
+// guild, along with the roles held by the bot itself
+func Fetch(session *discordgo.Session, guildID string) (*Live, error) {
+	guild, err := session.Guild(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching server: %w", err)
+	}
+
+	roles, err := session.GuildRoles(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching roles: %w", err)
+	}
+
+	channels, extras, err := fetchChannels(session, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching channels: %w", err)
+	}
+
+	webhooks, err := session.GuildWebhooks(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching webhooks: %w", err)
+	}
+
+	bot, err := session.User("@me")
+	if err != nil {
+		return nil, fmt.Errorf("fetching bot user: %w", err)
+	}
+
+	member, err := session.GuildMember(guildID, bot.ID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching bot member: %w", err)
+	}
+
+	return &Live{
+		GuildID:    guildID,
+		Guild:      guild,
+		Roles:      roles,
+		Channels:   channels,
+		Extras:     extras,
+		Webhooks:   webhooks,
+		BotRoleIDs: member.Roles,
+	}, nil
+}
+
+// ChannelExtra is the part of a channel that discordgo's Channel leaves out
+type ChannelExtra struct {
+	DefaultAutoArchiveDuration int     `json:"default_auto_archive_duration"`
+	RTCRegion                  *string `json:"rtc_region"` // nil means automatic
+	VideoQualityMode           int     `json:"video_quality_mode"`
+}
+
+// fetchChannels reads a guild's channels, decoding each twice: once as a
+// discordgo Channel and once for the fields it leaves out
+func fetchChannels(session *discordgo.Session, guildID string) ([]*discordgo.Channel, map[string]ChannelExtra, error) {
+	endpoint := discordgo.EndpointGuildChannels(guildID)
+	body, err := session.RequestWithBucketID("GET", endpoint, nil, endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var channels []*discordgo.Channel
+	if err := json.Unmarshal(body, &channels); err != nil {
+		return nil, nil, err
+	}
+
+	var raw []struct {
+		ID string `json:"id"`
+		ChannelExtra
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, nil, err
+	}
+
+	extras := make(map[string]ChannelExtra)
+	for _, ch := range raw {
+		extras[ch.ID] = ch.ChannelExtra
+	}
+
+	return channels, extras, nil
+}
+
+// BotTopRole returns the bot's highest role, or nil if it is unknown
+func (l *Live) BotTopRole() *discordgo.Role {
+	if l.BotRoleIDs == nil {
+		return nil
+	}
+
+	held := make(map[string]bool)
+	for _, id := range l.BotRoleIDs {
+		held[id] = true
+	}
+
+	var top *discordgo.Role
+	for _, role := range l.Roles {
+		if held[role.ID] && (top == nil || role.Position > top.Position) {
+			top = role
+		}
+	}
+
+	return top
+}
+
+// categories returns the live category channels
+func (l *Live) categories() []*discordgo.Channel {
+	var out []*discordgo.Channel
+	for _, ch := range l.Channels {
+		if ch.Type == discordgo.ChannelTypeGuildCategory {
+			out = append(out, ch)
+		}
+	}
+
+	return out
+}
+
+// channels returns the live non-category, non-thread channels
+func (l *Live) channels() []*discordgo.Channel {
+	var out []*discordgo.Channel
+	for _, ch := range l.Channels {
+		if ch.Type != discordgo.ChannelTypeGuildCategory && !ch.IsThread() {
+			out = append(out, ch)
+		}
+	}
+
+	return out
+}
+
+// channelName returns the name of a live channel by ID, or "" if unknown
+func (l *Live) channelName(id string) string {
+	for _, ch := range l.Channels {
+		if ch.ID == id {
+			return ch.Name
+		}
+	}
+
+	return ""
+}
+
+// roleName returns the name of a live role by ID, or the ID if unknown
+func (l *Live) roleName(id string) string {
+	for _, role := range l.Roles {
+		if role.ID == id {
+			return role.Name
+		}
+	}
+
+	return id
+}
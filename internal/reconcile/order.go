@@ -0,0 +1,164 @@
+package reconcile
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/snowflake"
+	"github.com/bwmarrin/discordgo"
+)
+
+// channelPosition is one entry of the bulk channel reorder payload.
+// discordgo's GuildChannelsReorder sends only IDs and positions, so it
+// can't move channels between categories. A nil parent is sent as null,
+// which takes a channel out of its category.
+type channelPosition struct {
+	ID       string  `json:"id"`
+	Position int     `json:"position"`
+	ParentID *string `json:"parent_id"`
+}
+
+// uncategorized labels the channels outside any category in plan output
+const uncategorized = "(no category)"
+
+// diffOrder returns a single bulk reorder that puts categories, and the
+// channels within each category or outside any, in config order, moving any
+// channel whose parent differs from config. Discord renormalizes positions,
+// so orders are compared by rank rather than by raw position. It returns nil
+// if the sidebar already matches.
+func diffOrder(p *Plan, cfg *config.Config, live *Live, categories, channels map[string]*discordgo.Channel) *Change {
+	names := make(map[string]string)
+	for _, category := range cfg.Channels.Categories {
+		if existing, ok := categories[category.StateKey()]; ok {
+			names[existing.ID] = category.Name
+		}
+	}
+	for _, ch := range allChannels(cfg) {
+		if existing, ok := channels[ch.StateKey()]; ok {
+			names[existing.ID] = ch.Name
+		}
+	}
+
+	ordered := sortedCategories(cfg.Channels.Categories)
+	var fields []Field
+
+	var wantTop []string
+	for _, ch := range sortedChannels(cfg.Channels.Channels) {
+		wantTop = append(wantTop, ch.Name)
+	}
+	haveTop := liveOrder(live.channels(), names, func(ch *discordgo.Channel) bool { return ch.ParentID == "" })
+	if !slices.Equal(haveTop, wantTop) {
+		fields = append(fields, Field{Name: uncategorized, Old: strings.Join(haveTop, ", "), New: strings.Join(wantTop, ", ")})
+	}
+
+	var wantCategories []string
+	for _, category := range ordered {
+		wantCategories = append(wantCategories, category.Name)
+	}
+	haveCategories := liveOrder(live.categories(), names, func(*discordgo.Channel) bool { return true })
+	if !slices.Equal(haveCategories, wantCategories) {
+		fields = append(fields, Field{Name: "categories", Old: strings.Join(haveCategories, ", "), New: strings.Join(wantCategories, ", ")})
+	}
+
+	for _, category := range ordered {
+		var want []string
+		for _, ch := range sortedChannels(category.Channels) {
+			want = append(want, ch.Name)
+		}
+
+		var have []string
+		if parent, ok := categories[category.StateKey()]; ok {
+			have = liveOrder(live.channels(), names, func(ch *discordgo.Channel) bool { return ch.ParentID == parent.ID })
+		}
+
+		if !slices.Equal(have, want) {
+			fields = append(fields, Field{Name: category.Name, Old: strings.Join(have, ", "), New: strings.Join(want, ", ")})
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return &Change{
+		Action: ActionUpdate,
+		Kind:   KindOrder,
+		Name:   "categories and channels",
+		Fields: fields,
+		apply: func(session *discordgo.Session) error {
+			// IDs are resolved now, since some may have been created in this
+			// run. Anything still without an ID, such as a create left out of
+			// a restore, is left out, along with the channels of a category
+			// without one.
+			var positions []channelPosition
+			for i, ch := range sortedChannels(cfg.Channels.Channels) {
+				if id := p.ids.channels[ch.StateKey()]; id != "" {
+					positions = append(positions, channelPosition{ID: id, Position: i})
+				}
+			}
+			for i, category := range ordered {
+				parentID := p.ids.categories[category.StateKey()]
+				if parentID == "" {
+					continue
+				}
+				positions = append(positions, channelPosition{ID: parentID, Position: i})
+
+				for j, ch := range sortedChannels(category.Channels) {
+					if id := p.ids.channels[ch.StateKey()]; id != "" {
+						positions = append(positions, channelPosition{ID: id, Position: j, ParentID: &parentID})
+					}
+				}
+			}
+			if len(positions) == 0 {
+				return nil
+			}
+
+			endpoint := discordgo.EndpointGuildChannels(p.guildID)
+			_, err := session.RequestWithBucketID("PATCH", endpoint, positions, endpoint)
+			return err
+		},
+	}
+}
+
+// liveOrder returns the config names of the managed live channels that
+// satisfy keep, in sidebar order
+func liveOrder(chs []*discordgo.Channel, names map[string]string, keep func(*discordgo.Channel) bool) []string {
+	var managed []*discordgo.Channel
+	for _, ch := range chs {
+		if _, ok := names[ch.ID]; ok && keep(ch) {
+			managed = append(managed, ch)
+		}
+	}
+
+	sort.SliceStable(managed, func(i, j int) bool {
+		if managed[i].Position != managed[j].Position {
+			return managed[i].Position < managed[j].Position
+		}
+		return snowflake.Less(managed[i].ID, managed[j].ID)
+	})
+
+	var order []string
+	for _, ch := range managed {
+		order = append(order, names[ch.ID])
+	}
+
+	return order
+}
+
+// sortedCategories returns categories ordered by their configured position,
+// keeping file order for ties
+func sortedCategories(categories []config.Category) []config.Category {
+	sorted := append([]config.Category(nil), categories...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Position < sorted[j].Position })
+	return sorted
+}
+
+// sortedChannels returns channels ordered by their configured position,
+// keeping file order for ties
+func sortedChannels(channels []config.Channel) []config.Channel {
+	sorted := append([]config.Channel(nil), channels...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Position < sorted[j].Position })
+	return sorted
+}
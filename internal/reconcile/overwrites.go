@@ -0,0 +1,245 @@
+package reconcile
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/permissions"
+	"github.com/bwmarrin/discordgo"
+)
+
+// overwrite is a desired channel permission overwrite, keyed by its config
+// target: "everyone", a role name or key, or "user:<id>" for a member
+type overwrite struct {
+	target string
+	perms  config.PermissionSet
+}
+
+// desiredOverwrites returns configured overwrites, @everyone first and the
+// rest sorted by target
+func desiredOverwrites(perms map[string]config.PermissionSet) []overwrite {
+	var overwrites []overwrite
+	for target, perms := range perms {
+		overwrites = append(overwrites, overwrite{target: target, perms: perms})
+	}
+
+	sort.Slice(overwrites, func(i, j int) bool {
+		a, b := overwrites[i].target, overwrites[j].target
+		if (a == config.EveryoneTarget) != (b == config.EveryoneTarget) {
+			return a == config.EveryoneTarget
+		}
+		return a < b
+	})
+
+	return overwrites
+}
+
+// targetID resolves an overwrite target to a Discord ID. It returns "" for
+// a role that hasn't been created yet.
+func (p *Plan) targetID(target string) string {
+	switch {
+	case target == config.EveryoneTarget:
+		// @everyone's role ID is the same as the guild ID
+		return p.guildID
+	case strings.HasPrefix(target, config.MemberTargetPrefix):
+		return strings.TrimPrefix(target, config.MemberTargetPrefix)
+	default:
+		return p.ids.roles[p.cfg.Roles.FindRole(target).StateKey()]
+	}
+}
+
+func targetType(target string) discordgo.PermissionOverwriteType {
+	if strings.HasPrefix(target, config.MemberTargetPrefix) {
+		return discordgo.PermissionOverwriteTypeMember
+	}
+
+	return discordgo.PermissionOverwriteTypeRole
+}
+
+// resolveOverwrites converts desired overwrites into Discord overwrites.
+// Call it at apply time so roles created earlier in the run resolve.
+func (p *Plan) resolveOverwrites(overwrites []overwrite) []*discordgo.PermissionOverwrite {
+	var resolved []*discordgo.PermissionOverwrite
+	for _, ow := range overwrites {
+		allow, deny := overwriteBits(ow.perms)
+		resolved = append(resolved, &discordgo.PermissionOverwrite{
+			ID:    p.targetID(ow.target),
+			Type:  targetType(ow.target),
+			Allow: allow,
+			Deny:  deny,
+		})
+	}
+
+	return resolved
+}
+
+// diffOverwrites returns changes for overwrites on a matched channel or
+// category that are missing, differ from config, or are no longer in config.
+// Only overwrites for @everyone, managed roles, and members are removed;
+// overwrites for unmanaged roles (such as a bot's own role) are left alone.
+func diffOverwrites(p *Plan, live *Live, name string, perms map[string]config.PermissionSet, existing *discordgo.Channel) []*Change {
+	current := make(map[string]*discordgo.PermissionOverwrite)
+	for _, ow := range existing.PermissionOverwrites {
+		current[ow.ID] = ow
+	}
+
+	channelID := existing.ID
+	wanted := make(map[string]bool)
+
+	var changes []*Change
+	for _, want := range desiredOverwrites(perms) {
+		allow, deny := overwriteBits(want.perms)
+		id := p.targetID(want.target)
+		wanted[id] = true
+
+		change := &Change{
+			Kind: KindOverwrite,
+			Name: name + "/" + want.target,
+			apply: func(session *discordgo.Session) error {
+				return session.ChannelPermissionSet(channelID, p.targetID(want.target), targetType(want.target), allow, deny)
+			},
+		}
+
+		have, ok := current[id]
+		switch {
+		case id == "" || !ok:
+			change.Action = ActionCreate
+			change.Fields = []Field{
+				{Name: "allow", New: formatPermissions(allow)},
+				{Name: "deny", New: formatPermissions(deny)},
+			}
+		case have.Allow != allow || have.Deny != deny:
+			change.Action = ActionUpdate
+			if have.Allow != allow {
+				change.Fields = append(change.Fields, Field{Name: "allow", Old: formatPermissions(have.Allow), New: formatPermissions(allow)})
+			}
+			if have.Deny != deny {
+				change.Fields = append(change.Fields, Field{Name: "deny", Old: formatPermissions(have.Deny), New: formatPermissions(deny)})
+			}
+		default:
+			continue
+		}
+
+		changes = append(changes, change)
+	}
+
+	for _, ow := range existing.PermissionOverwrites {
+		if wanted[ow.ID] || !p.managedTarget(ow) {
+			continue
+		}
+
+		targetID := ow.ID
+		changes = append(changes, &Change{
+			Action: ActionDelete,
+			Kind:   KindOverwrite,
+			Name:   name + "/" + targetName(p, live, ow),
+			apply: func(session *discordgo.Session) error {
+				return session.ChannelPermissionDelete(channelID, targetID)
+			},
+		})
+	}
+
+	return changes
+}
+
+// managedTarget reports whether a live overwrite is for a target config
+// manages: @everyone, a managed role, or a member
+func (p *Plan) managedTarget(ow *discordgo.PermissionOverwrite) bool {
+	if ow.Type == discordgo.PermissionOverwriteTypeMember || ow.ID == p.guildID {
+		return true
+	}
+	for _, id := range p.ids.roles {
+		if id == ow.ID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// targetName returns the config-style target name for a live overwrite
+func targetName(p *Plan, live *Live, ow *discordgo.PermissionOverwrite) string {
+	switch {
+	case ow.Type == discordgo.PermissionOverwriteTypeMember:
+		return config.MemberTargetPrefix + ow.ID
+	case ow.ID == p.guildID:
+		return config.EveryoneTarget
+	default:
+		return live.roleName(ow.ID)
+	}
+}
+
+// diffSync returns an update that resyncs a channel with its category when
+// the channel inherits the category's permissions but its overwrites have
+// drifted, or nil if it is in sync. The channel's overwrites are replaced
+// with the category's desired ones, plus any the live category has for
+// unmanaged roles, which is what Discord itself treats as synced.
+func diffSync(p *Plan, live *Live, category config.Category, ch config.Channel, existing, parent *discordgo.Channel) *Change {
+	var unmanaged []*discordgo.PermissionOverwrite
+	if parent != nil {
+		for _, ow := range parent.PermissionOverwrites {
+			if !p.managedTarget(ow) {
+				unmanaged = append(unmanaged, ow)
+			}
+		}
+	}
+
+	target := append(p.resolveOverwrites(desiredOverwrites(category.Permissions)), unmanaged...)
+	if sameOverwrites(existing.PermissionOverwrites, target) {
+		return nil
+	}
+
+	old := "out of sync with " + category.Name
+	if parent != nil && sameOverwrites(existing.PermissionOverwrites, parent.PermissionOverwrites) {
+		old = "synced with " + category.Name + "'s previous permissions"
+	}
+
+	channelID := existing.ID
+	return &Change{
+		Action: ActionUpdate,
+		Kind:   KindChannel,
+		Name:   ch.Name,
+		Fields: []Field{{Name: "permissions", Old: old, New: "synced with " + category.Name}},
+		apply: func(session *discordgo.Session) error {
+			// Resolve again, since roles may have been created in this run
+			overwrites := append(p.resolveOverwrites(desiredOverwrites(category.Permissions)), unmanaged...)
+			if overwrites == nil {
+				overwrites = []*discordgo.PermissionOverwrite{}
+			}
+			return editChannel(session, channelID, map[string]interface{}{"permission_overwrites": overwrites})
+		},
+	}
+}
+
+// sameOverwrites reports whether two overwrite lists are equal as sets
+func sameOverwrites(a, b []*discordgo.PermissionOverwrite) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	byID := make(map[string]*discordgo.PermissionOverwrite)
+	for _, ow := range a {
+		byID[ow.ID] = ow
+	}
+	for _, ow := range b {
+		match, ok := byID[ow.ID]
+		if !ok || match.Type != ow.Type || match.Allow != ow.Allow || match.Deny != ow.Deny {
+			return false
+		}
+	}
+
+	return true
+}
+
+func overwriteBits(perms map[string]bool) (allow, deny int64) {
+	for perm, value := range perms {
+		if value {
+			allow |= permissions.Value(perm)
+		} else {
+			deny |= permissions.Value(perm)
+		}
+	}
+
+	return allow, deny
+}
@@ -0,0 +1,333 @@
+package reconcile
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/state"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Action is what a change does to a Discord resource
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+func (a Action) symbol() string {
+	switch a {
+	case ActionCreate:
+		return "+"
+	case ActionDelete:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+func (a Action) verb() string {
+	switch a {
+	case ActionCreate:
+		return "creating"
+	case ActionDelete:
+		return "deleting"
+	default:
+		return "updating"
+	}
+}
+
+func (a Action) past() string {
+	switch a {
+	case ActionCreate:
+		return "Created"
+	case ActionDelete:
+		return "Deleted"
+	default:
+		return "Updated"
+	}
+}
+
+// Kind is the type of Discord resource a change applies to
+type Kind string
+
+const (
+	KindServer    Kind = "server"
+	KindRole      Kind = "role"
+	KindCategory  Kind = "category"
+	KindChannel   Kind = "channel"
+	KindOverwrite Kind = "overwrite"
+	KindWebhook   Kind = "webhook"
+	KindOrder     Kind = "order"
+)
+
+// Field is a single attribute that differs between config and Discord
+type Field struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// Change is one create, update, or delete against the guild
+type Change struct {
+	Action Action
+	Kind   Kind
+	Name   string
+	Fields []Field
+
+	id    string // Discord ID of the live resource, for deletes
+	apply func(session *discordgo.Session) error
+}
+
+// Plan is the ordered set of changes needed to make the guild match config.
+// Deletes of live resources that are not in config are kept apart in Prune
+// and only applied when the pruning mode is delete.
+type Plan struct {
+	Changes []*Change
+	Prune   []*Change
+	// PruneMode is the config pruning mode the plan was built with
+	PruneMode string
+	// Warnings describe config the plan leaves out because the bot can't
+	// apply it
+	Warnings []string
+
+	guildID  string
+	cfg      *config.Config
+	state    *state.State
+	ids      *ids
+	webhooks []*discordgo.Webhook
+}
+
+// ids tracks the Discord IDs of managed resources by config state key. It is
+// seeded from matched live resources and filled in as resources are created,
+// so later changes can reference resources created earlier in the same run.
+type ids struct {
+	roles      map[string]string
+	categories map[string]string
+	channels   map[string]string
+	tags       map[string]string
+	webhooks   map[string]string
+}
+
+// Build compares config against a live snapshot and returns the changes
+// needed to reconcile them. Live resources are matched to config entries by
+// the IDs recorded in st first, then by name. Creates and updates come first
+// (roles and the role hierarchy, then categories, then channels and their
+// overwrites, then the bulk channel reorder, then server settings, which may
+// reference channels, then webhooks), followed by deletes. Live roles,
+// categories, and channels that are not in config become prune candidates,
+// minus anything protected, ordered channels first and roles last.
+func Build(cfg *config.Config, live *Live, st *state.State) (*Plan, error) {
+	p := &Plan{
+		PruneMode: cfg.Server.Pruning.Mode,
+		guildID:   cfg.GuildID,
+		cfg:       cfg,
+		state:     st,
+		ids: &ids{
+			roles:      make(map[string]string),
+			categories: make(map[string]string),
+			channels:   make(map[string]string),
+			tags:       make(map[string]string),
+			webhooks:   make(map[string]string),
+		},
+	}
+
+	roleDeletes := diffRoles(p, cfg, live)
+	channelDeletes := diffChannels(p, cfg, live)
+	if change := diffServer(p, cfg, live); change != nil {
+		p.add(change)
+	}
+	webhookDeletes := diffWebhooks(p, cfg, live)
+
+	p.Changes = append(p.Changes, webhookDeletes...)
+
+	if p.PruneMode != config.PruneOff {
+		protected := make(map[string]bool)
+		for _, ref := range cfg.Server.Pruning.Protected {
+			protected[ref] = true
+		}
+
+		for _, c := range append(channelDeletes, roleDeletes...) {
+			if !protected[c.Name] && !protected[c.id] {
+				p.Prune = append(p.Prune, c)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// channelID resolves a config channel key to its Discord ID at apply time
+func (p *Plan) channelID(key string) (string, error) {
+	id, ok := p.ids.channels[key]
+	if !ok {
+		return "", fmt.Errorf("target channel not found: %s", key)
+	}
+
+	return id, nil
+}
+
+// trackedIDs returns the IDs recorded in a state section for an entry, under
+// its current key first and then under any previous names it was saved as
+func trackedIDs(section map[string]string, key string, previousNames []string) []string {
+	var ids []string
+	for _, name := range names(key, previousNames) {
+		if id, ok := section[name]; ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// names returns a name followed by its previous names, in match order
+func names(name string, previousNames []string) []string {
+	return append([]string{name}, previousNames...)
+}
+
+func (p *Plan) add(c *Change) {
+	p.Changes = append(p.Changes, c)
+}
+
+func (p *Plan) warn(format string, args ...interface{}) {
+	p.Warnings = append(p.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Only drops every change for which keep returns false. Setup uses it to
+// create what is missing while leaving existing resources untouched.
+func (p *Plan) Only(keep func(c *Change) bool) {
+	filter := func(changes []*Change) []*Change {
+		var kept []*Change
+		for _, c := range changes {
+			if keep(c) {
+				kept = append(kept, c)
+			}
+		}
+		return kept
+	}
+
+	p.Changes = filter(p.Changes)
+	p.Prune = filter(p.Prune)
+}
+
+// pruning returns the prune candidates that Apply will delete
+func (p *Plan) pruning() []*Change {
+	if p.PruneMode != config.PruneDelete {
+		return nil
+	}
+	return p.Prune
+}
+
+// Empty reports whether applying the plan would change nothing. Prune
+// candidates only count when the pruning mode is delete.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0 && len(p.pruning()) == 0
+}
+
+// Summary returns a one-line count of changes by action
+func (p *Plan) Summary() string {
+	counts := make(map[Action]int)
+	for _, c := range p.Changes {
+		counts[c.Action]++
+	}
+
+	summary := fmt.Sprintf("%d to create, %d to update, %d to delete",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete])
+
+	switch {
+	case len(p.pruning()) > 0:
+		summary += fmt.Sprintf(", %d to prune", len(p.Prune))
+	case len(p.Prune) > 0:
+		summary += fmt.Sprintf(", %d unmanaged (not pruned)", len(p.Prune))
+	}
+
+	return summary
+}
+
+// Print writes a human-readable description of every change, followed by
+// any prune candidates
+func (p *Plan) Print(w io.Writer) {
+	printChanges(w, p.Changes)
+	p.PrintPrune(w)
+}
+
+// PrintWarnings writes the plan's warnings
+func (p *Plan) PrintWarnings(w io.Writer) {
+	for _, warning := range p.Warnings {
+		fmt.Fprintf(w, "  ⚠ %s\n", warning)
+	}
+}
+
+// PrintPrune writes the prune candidates under a separate heading
+func (p *Plan) PrintPrune(w io.Writer) {
+	if len(p.Prune) == 0 {
+		return
+	}
+
+	if p.PruneMode == config.PruneDelete {
+		fmt.Fprintln(w, "  Unmanaged resources to prune (pruning: delete):")
+	} else {
+		fmt.Fprintf(w, "  Unmanaged resources, not deleted (pruning: %s):\n", p.PruneMode)
+	}
+	printChanges(w, p.Prune)
+}
+
+func printChanges(w io.Writer, changes []*Change) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "  %s %s: %s\n", c.Action.symbol(), c.Kind, c.Name)
+		for _, f := range c.Fields {
+			if c.Action == ActionCreate {
+				fmt.Fprintf(w, "      %s: %s\n", f.Name, f.New)
+			} else {
+				fmt.Fprintf(w, "      %s: %s → %s\n", f.Name, f.Old, f.New)
+			}
+		}
+	}
+}
+
+// CreatedWebhooks returns the webhooks created by Apply, including their
+// tokens. Callers decide whether it is safe to print them.
+func (p *Plan) CreatedWebhooks() []*discordgo.Webhook {
+	return p.webhooks
+}
+
+// State returns the config-to-ID mapping for every managed resource that
+// was matched or created. It reflects the guild after Apply, or the guild as
+// it stands if the plan has not been applied.
+func (p *Plan) State() *state.State {
+	st := state.New(p.guildID)
+	for name, id := range p.ids.roles {
+		st.Roles[name] = id
+	}
+	for name, id := range p.ids.categories {
+		st.Categories[name] = id
+	}
+	for name, id := range p.ids.channels {
+		st.Channels[name] = id
+	}
+	for name, id := range p.ids.tags {
+		st.Tags[name] = id
+	}
+	for name, id := range p.ids.webhooks {
+		st.Webhooks[name] = id
+	}
+
+	return st
+}
+
+// Apply executes the plan against Discord in order, stopping at the first
+// error. Prune candidates are deleted last, and only in delete mode.
+func (p *Plan) Apply(session *discordgo.Session) error {
+	for _, c := range append(p.Changes, p.pruning()...) {
+		if err := c.apply(session); err != nil {
+			return fmt.Errorf("%s %s %s: %w", c.Action.verb(), c.Kind, c.Name, err)
+		}
+
+		fmt.Printf("  ✓ %s %s: %s\n", c.Action.past(), c.Kind, c.Name)
+	}
+
+	return nil
+}
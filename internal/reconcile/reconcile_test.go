@@ -0,0 +1,496 @@
+package reconcile
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/state"
+	"github.com/bwmarrin/discordgo"
+)
+
+const guildID = "1"
+
+// Live IDs of the test guild
+const (
+	memberID        = "10"
+	adminID         = "11"
+	botID           = "90"
+	communityID     = "100"
+	generalID       = "200"
+	announcementsID = "201"
+)
+
+// testConfig returns the config the test guild is built from
+func testConfig() *config.Config {
+	return &config.Config{
+		GuildID: guildID,
+		Roles: config.RolesConfig{Roles: []config.Role{
+			{Name: "Admin", Color: "#e74c3c", Hoist: true, Permissions: []string{"administrator"}},
+			{Name: "Member", Color: "#3498db", Permissions: []string{"send_messages", "add_reactions"}},
+		}},
+		Channels: config.ChannelsConfig{Categories: []config.Category{{
+			Name:     "COMMUNITY",
+			Position: 1,
+			Channels: []config.Channel{
+				{Name: "general", Type: "text", Topic: "Chat", Position: 1},
+				{Name: "announcements", Type: "text", Position: 2, Permissions: map[string]config.PermissionSet{
+					"everyone": {"send_messages": false},
+				}},
+			},
+		}}},
+		Server: config.ServerConfig{Pruning: config.PruningConfig{Mode: config.PruneReport}},
+	}
+}
+
+// testLive returns a guild that matches testConfig exactly
+func testLive() *Live {
+	return &Live{
+		GuildID: guildID,
+		Roles: []*discordgo.Role{
+			{ID: guildID, Name: "@everyone"},
+			{ID: memberID, Name: "Member", Position: 1, Color: 0x3498db, Permissions: discordgo.PermissionSendMessages | discordgo.PermissionAddReactions},
+			{ID: adminID, Name: "Admin", Position: 2, Color: 0xe74c3c, Hoist: true, Permissions: discordgo.PermissionAdministrator},
+			{ID: botID, Name: "Bot", Position: 3, Managed: true},
+		},
+		Channels: []*discordgo.Channel{
+			{ID: communityID, Name: "COMMUNITY", Type: discordgo.ChannelTypeGuildCategory},
+			{ID: generalID, Name: "general", Type: discordgo.ChannelTypeGuildText, ParentID: communityID, Topic: "Chat", Position: 0},
+			{ID: announcementsID, Name: "announcements", Type: discordgo.ChannelTypeGuildText, ParentID: communityID, Position: 1,
+				PermissionOverwrites: []*discordgo.PermissionOverwrite{
+					{ID: guildID, Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionSendMessages},
+				}},
+		},
+		BotRoleIDs: []string{botID},
+	}
+}
+
+func build(t *testing.T, cfg *config.Config, live *Live, st *state.State) *Plan {
+	t.Helper()
+	p, err := Build(cfg, live, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// describe lists changes the way Print heads them
+func describe(changes []*Change) []string {
+	var out []string
+	for _, c := range changes {
+		out = append(out, c.Action.symbol()+" "+string(c.Kind)+": "+c.Name)
+	}
+
+	return out
+}
+
+// checkChanges stops the test if changes aren't the ones wanted, since
+// later checks index into them
+func checkChanges(t *testing.T, what string, changes []*Change, want ...string) {
+	t.Helper()
+	if got := describe(changes); !slices.Equal(got, want) {
+		t.Fatalf("%s:\n  got  %s\n  want %s", what, strings.Join(got, "\n       "), strings.Join(want, "\n       "))
+	}
+}
+
+func checkField(t *testing.T, c *Change, name, old, new string) {
+	t.Helper()
+	for _, f := range c.Fields {
+		if f.Name == name {
+			if f.Old != old || f.New != new {
+				t.Errorf("%s %s: %s is %q → %q, want %q → %q", c.Kind, c.Name, name, f.Old, f.New, old, new)
+			}
+			return
+		}
+	}
+	t.Errorf("%s %s: no %s field", c.Kind, c.Name, name)
+}
+
+func TestBuildMatchesByName(t *testing.T) {
+	p := build(t, testConfig(), testLive(), state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes)
+	if !p.Empty() {
+		t.Error("plan for a matching guild isn't empty")
+	}
+
+	st := p.State()
+	if st.Roles["Admin"] != adminID || st.Categories["COMMUNITY"] != communityID || st.Channels["general"] != generalID {
+		t.Errorf("state doesn't track the matched resources: %+v", st)
+	}
+}
+
+func TestBuildCreatesMissing(t *testing.T) {
+	live := testLive()
+	live.Roles = []*discordgo.Role{live.Roles[0], live.Roles[3]}
+	live.Channels = nil
+
+	p := build(t, testConfig(), live, state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes,
+		"+ role: Admin",
+		"+ role: Member",
+		"~ order: roles",
+		"+ category: COMMUNITY",
+		"+ channel: general",
+		"+ channel: announcements",
+		"~ order: categories and channels",
+	)
+}
+
+func TestBuildUpdates(t *testing.T) {
+	live := testLive()
+	live.Roles[1].Color = 0xffffff
+	live.Channels[1].Topic = "Old topic"
+	live.Channels[2].PermissionOverwrites[0].Deny = discordgo.PermissionAddReactions
+
+	p := build(t, testConfig(), live, state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes,
+		"~ role: Member",
+		"~ channel: general",
+		"~ overwrite: announcements/everyone",
+	)
+	checkField(t, p.Changes[0], "color", "#ffffff", "#3498db")
+	checkField(t, p.Changes[1], "topic", `"Old topic"`, `"Chat"`)
+	checkField(t, p.Changes[2], "deny", "add_reactions", "send_messages")
+}
+
+func TestBuildUncategorized(t *testing.T) {
+	cfg := testConfig()
+	cfg.Channels.Channels = []config.Channel{{Name: "welcome", Type: "text"}}
+
+	p := build(t, cfg, testLive(), state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes,
+		"+ channel: welcome",
+		"~ order: categories and channels",
+	)
+	checkField(t, p.Changes[1], uncategorized, "", "welcome")
+	for _, f := range p.Changes[0].Fields {
+		if f.Name == "category" {
+			t.Errorf("uncategorized channel is created in %s", f.New)
+		}
+	}
+}
+
+func TestBuildChannelSettings(t *testing.T) {
+	cfg := testConfig()
+	category := &cfg.Channels.Categories[0]
+	category.Channels[0].Slowmode = 30
+	category.Channels = append(category.Channels, config.Channel{Name: "lounge", Type: "voice", Position: 3, UserLimit: 10, RTCRegion: "rotterdam"})
+
+	live := testLive()
+	live.Channels = append(live.Channels, &discordgo.Channel{ID: "202", Name: "lounge", Type: discordgo.ChannelTypeGuildVoice, ParentID: communityID, Position: 2, Bitrate: 64000})
+
+	p := build(t, cfg, live, state.New(guildID))
+
+	// The bitrate isn't set in config, so it is left as it is
+	checkChanges(t, "changes", p.Changes,
+		"~ channel: general",
+		"~ channel: lounge",
+	)
+	checkField(t, p.Changes[0], "slowmode", "off", "30s")
+	if len(p.Changes[1].Fields) != 2 {
+		t.Errorf("lounge fields %+v, want user_limit and rtc_region", p.Changes[1].Fields)
+	}
+	checkField(t, p.Changes[1], "user_limit", "unlimited", "10")
+	checkField(t, p.Changes[1], "rtc_region", "automatic", "rotterdam")
+}
+
+func TestBuildOverwrites(t *testing.T) {
+	cfg := testConfig()
+	cfg.Channels.Categories[0].Channels[1].Permissions["Member"] = config.PermissionSet{"add_reactions": true}
+
+	live := testLive()
+	live.Channels[2].PermissionOverwrites = []*discordgo.PermissionOverwrite{
+		{ID: guildID, Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionAddReactions},
+		{ID: "42", Type: discordgo.PermissionOverwriteTypeMember, Allow: discordgo.PermissionSendMessages},
+		// The bot's role isn't managed, so its overwrite is left alone
+		{ID: botID, Type: discordgo.PermissionOverwriteTypeRole, Allow: discordgo.PermissionSendMessages},
+	}
+
+	p := build(t, cfg, live, state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes,
+		"~ overwrite: announcements/everyone",
+		"+ overwrite: announcements/Member",
+		"- overwrite: announcements/user:42",
+	)
+	checkField(t, p.Changes[0], "deny", "add_reactions", "send_messages")
+	checkField(t, p.Changes[1], "allow", "", "add_reactions")
+}
+
+func TestBuildSyncsChannels(t *testing.T) {
+	cfg := testConfig()
+	cfg.Channels.Categories[0].Permissions = map[string]config.PermissionSet{
+		"everyone": {"add_reactions": false},
+		"Member":   {"add_reactions": true},
+	}
+
+	noReactions := &discordgo.PermissionOverwrite{ID: guildID, Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionAddReactions}
+	members := &discordgo.PermissionOverwrite{ID: memberID, Type: discordgo.PermissionOverwriteTypeRole, Allow: discordgo.PermissionAddReactions}
+
+	live := testLive()
+	live.Channels[0].PermissionOverwrites = []*discordgo.PermissionOverwrite{noReactions, members}
+	live.Channels[1].PermissionOverwrites = []*discordgo.PermissionOverwrite{noReactions, members}
+	// announcements has its own permissions, layered over the category's
+	live.Channels[2].PermissionOverwrites = []*discordgo.PermissionOverwrite{
+		{ID: guildID, Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionAddReactions | discordgo.PermissionSendMessages},
+		members,
+	}
+
+	checkChanges(t, "changes", build(t, cfg, live, state.New(guildID)).Changes)
+
+	// general drifted from its category
+	live.Channels[1].PermissionOverwrites = []*discordgo.PermissionOverwrite{noReactions}
+	p := build(t, cfg, live, state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes, "~ channel: general")
+	checkField(t, p.Changes[0], "permissions", "out of sync with COMMUNITY", "synced with COMMUNITY")
+
+	// The category's permissions changed, and general still has the old ones
+	live.Channels[1].PermissionOverwrites = []*discordgo.PermissionOverwrite{noReactions, members}
+	delete(cfg.Channels.Categories[0].Permissions, "Member")
+	cfg.Channels.Categories[0].Channels[1].Permissions["Member"] = config.PermissionSet{"add_reactions": true}
+	p = build(t, cfg, live, state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes,
+		"- overwrite: COMMUNITY/Member",
+		"~ channel: general",
+	)
+	checkField(t, p.Changes[1], "permissions", "synced with COMMUNITY's previous permissions", "synced with COMMUNITY")
+}
+
+func TestBuildOrder(t *testing.T) {
+	live := testLive()
+	live.Roles[1].Position, live.Roles[2].Position = 2, 1
+	live.Channels[1].Position, live.Channels[2].Position = 1, 0
+
+	p := build(t, testConfig(), live, state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes,
+		"~ order: roles",
+		"~ order: categories and channels",
+	)
+	checkField(t, p.Changes[0], "hierarchy", "Member, Admin", "Admin, Member")
+	checkField(t, p.Changes[1], "COMMUNITY", "announcements, general", "general, announcements")
+
+	// Discord breaks ties between equal positions by ID, oldest first
+	live = testLive()
+	live.Channels[1].Position, live.Channels[2].Position = 0, 0
+	checkChanges(t, "changes for tied positions", build(t, testConfig(), live, state.New(guildID)).Changes)
+}
+
+func TestBuildRolesAboveBot(t *testing.T) {
+	live := testLive()
+	live.Roles[2].Position, live.Roles[3].Position = 3, 2
+	live.Roles[1].Color = 0
+	live.Roles[2].Color = 0
+
+	// Admin is left alone, and the rest of the plan still built
+	p := build(t, testConfig(), live, state.New(guildID))
+	checkChanges(t, "changes", p.Changes, "~ role: Member")
+	if len(p.Warnings) != 1 || !strings.Contains(p.Warnings[0], `role "Bot" are left as they are: Admin`) {
+		t.Errorf("got warnings %q, want one naming Admin", p.Warnings)
+	}
+	if p.State().Roles["Admin"] != adminID {
+		t.Error("Admin isn't tracked in state")
+	}
+
+	// Without the bot's roles the hierarchy can't be checked
+	live.BotRoleIDs = nil
+	p = build(t, testConfig(), live, state.New(guildID))
+	checkChanges(t, "changes without the bot's roles", p.Changes, "~ role: Admin", "~ role: Member")
+	if len(p.Warnings) != 0 {
+		t.Errorf("got warnings %q", p.Warnings)
+	}
+}
+
+func TestBuildServer(t *testing.T) {
+	cfg := testConfig()
+	cfg.Server.Name = "Test"
+	cfg.Server.Settings.VerificationLevel = "medium"
+	cfg.Server.Settings.DefaultNotificationLevel = "only_mentions"
+
+	live := testLive()
+	live.Guild = &discordgo.Guild{
+		Name:                        "Test",
+		VerificationLevel:           discordgo.VerificationLevelLow,
+		DefaultMessageNotifications: discordgo.MessageNotificationsOnlyMentions,
+		Features:                    []discordgo.GuildFeature{discordgo.GuildFeatureCommunity},
+	}
+
+	p := build(t, cfg, live, state.New(guildID))
+
+	// explicit_content_filter is unset, so it is left as it is
+	checkChanges(t, "changes", p.Changes, "~ server: Test")
+	if len(p.Changes[0].Fields) != 2 {
+		t.Errorf("fields %+v, want verification_level and community", p.Changes[0].Fields)
+	}
+	checkField(t, p.Changes[0], "verification_level", "low", "medium")
+	checkField(t, p.Changes[0], "community", "true", "false")
+}
+
+func TestBuildSpecialChannels(t *testing.T) {
+	cfg := testConfig()
+	cfg.Server.Channels = config.SpecialChannels{
+		System:             "general",
+		Rules:              "announcements",
+		SystemChannelFlags: []string{"suppress_join_notifications"},
+	}
+
+	live := testLive()
+	live.Guild = &discordgo.Guild{
+		Name:               "Test",
+		SystemChannelID:    generalID,
+		SystemChannelFlags: 1 | 1<<10,
+	}
+
+	p := build(t, cfg, live, state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes, "~ server: Test")
+	if len(p.Changes[0].Fields) != 2 {
+		t.Errorf("fields %+v, want rules_channel and system_channel_flags", p.Changes[0].Fields)
+	}
+	checkField(t, p.Changes[0], "rules_channel", "none", "#announcements")
+	checkField(t, p.Changes[0], "system_channel_flags", "suppress_join_notifications, unknown 0x400", "suppress_join_notifications")
+}
+
+func TestBuildMatchesByStateID(t *testing.T) {
+	live := testLive()
+	live.Roles[2].Name = "Administrator"
+	live.Channels[0].Name = "Community"
+	live.Channels[1].Name = "chat"
+
+	// A role and channel named like config entries, which the IDs in state
+	// take precedence over
+	live.Roles = append(live.Roles, &discordgo.Role{ID: "12", Name: "Admin"})
+	live.Channels = append(live.Channels, &discordgo.Channel{ID: "202", Name: "general", Type: discordgo.ChannelTypeGuildText, ParentID: communityID, Position: 2})
+
+	st := state.New(guildID)
+	st.Roles["Admin"] = adminID
+	st.Categories["COMMUNITY"] = communityID
+	st.Channels["general"] = generalID
+
+	p := build(t, testConfig(), live, st)
+
+	checkChanges(t, "changes", p.Changes,
+		"~ role: Admin",
+		"~ category: COMMUNITY",
+		"~ channel: general",
+	)
+	checkField(t, p.Changes[0], "name", "Administrator", "Admin")
+	checkField(t, p.Changes[1], "name", "Community", "COMMUNITY")
+	checkField(t, p.Changes[2], "name", "chat", "general")
+	checkChanges(t, "prune", p.Prune,
+		"- channel: general",
+		"- role: Admin",
+	)
+	if p.Prune[0].id != "202" || p.Prune[1].id != "12" {
+		t.Errorf("prune targets the wrong IDs: %s, %s", p.Prune[0].id, p.Prune[1].id)
+	}
+}
+
+func TestBuildPreviousNames(t *testing.T) {
+	cfg := testConfig()
+	cfg.Roles.Roles[0].PreviousNames = []string{"Staff"}
+	cfg.Channels.Categories[0].PreviousNames = []string{"Community"}
+	cfg.Channels.Categories[0].Channels[0].PreviousNames = []string{"lobby"}
+
+	live := testLive()
+	live.Roles[2].Name = "Staff"
+	live.Channels[0].Name = "Community"
+	live.Channels[1].Name = "lobby"
+
+	p := build(t, cfg, live, state.New(guildID))
+
+	checkChanges(t, "changes", p.Changes,
+		"~ role: Admin",
+		"~ category: COMMUNITY",
+		"~ channel: general",
+	)
+	checkField(t, p.Changes[0], "name", "Staff", "Admin")
+	checkField(t, p.Changes[1], "name", "Community", "COMMUNITY")
+	checkField(t, p.Changes[2], "name", "lobby", "general")
+}
+
+func TestBuildKeys(t *testing.T) {
+	cfg := testConfig()
+	cfg.Roles.Roles[0].Key = "admins"
+	cfg.Roles.Roles[0].Name = "Staff"
+	cfg.Channels.Categories[0].Channels[0].Key = "chat"
+	cfg.Channels.Categories[0].Channels[0].Name = "lobby"
+
+	st := state.New(guildID)
+	st.Roles["admins"] = adminID
+	st.Channels["chat"] = generalID
+
+	p := build(t, cfg, testLive(), st)
+
+	// Renaming a keyed entry renames it in place, and state stays under the
+	// key
+	checkChanges(t, "changes", p.Changes,
+		"~ role: Staff",
+		"~ channel: lobby",
+	)
+	checkField(t, p.Changes[0], "name", "Admin", "Staff")
+	checkField(t, p.Changes[1], "name", "general", "lobby")
+	if got := p.State(); got.Roles["admins"] != adminID || got.Channels["chat"] != generalID {
+		t.Errorf("state doesn't track keyed entries by key: %+v", got)
+	}
+}
+
+func TestBuildPrune(t *testing.T) {
+	cfg := testConfig()
+	cfg.Server.Pruning.Protected = []string{"keep", "302"}
+
+	live := testLive()
+	live.Roles = append(live.Roles,
+		&discordgo.Role{ID: "20", Name: "stray", Position: 1},
+		&discordgo.Role{ID: "21", Name: "keep", Position: 1},
+	)
+	live.Channels = append(live.Channels,
+		&discordgo.Channel{ID: "300", Name: "old", Type: discordgo.ChannelTypeGuildText, Position: 3},
+		&discordgo.Channel{ID: "301", Name: "ARCHIVE", Type: discordgo.ChannelTypeGuildCategory, Position: 1},
+		&discordgo.Channel{ID: "302", Name: "pinned", Type: discordgo.ChannelTypeGuildText, Position: 4},
+	)
+
+	p := build(t, cfg, live, state.New(guildID))
+
+	// Channels go before their categories, and roles last; @everyone, the
+	// bot's managed role, and protected names and IDs are left alone
+	checkChanges(t, "changes", p.Changes)
+	checkChanges(t, "prune", p.Prune,
+		"- channel: old",
+		"- category: ARCHIVE",
+		"- role: stray",
+	)
+	if !p.Empty() {
+		t.Error("prune candidates count as changes in report mode")
+	}
+
+	p.PruneMode = config.PruneDelete
+	if p.Empty() {
+		t.Error("prune candidates don't count as changes in delete mode")
+	}
+
+	cfg.Server.Pruning.Mode = config.PruneOff
+	p = build(t, cfg, live, state.New(guildID))
+	checkChanges(t, "prune with pruning off", p.Prune)
+}
+
+func TestBuildPruneBotRoles(t *testing.T) {
+	live := testLive()
+	live.Roles = append(live.Roles,
+		&discordgo.Role{ID: "20", Name: "Helper", Position: 1},
+		&discordgo.Role{ID: "21", Name: "Owner", Position: 4},
+	)
+	live.BotRoleIDs = append(live.BotRoleIDs, "20")
+
+	// Deleting a role the bot holds, or one above it, would cut the bot off
+	p := build(t, testConfig(), live, state.New(guildID))
+	checkChanges(t, "prune", p.Prune)
+}
@@ -0,0 +1,370 @@
+package reconcile
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/permissions"
+	"github.com/Work-Fort/Discord/internal/snowflake"
+	"github.com/bwmarrin/discordgo"
+)
+
+// diffRoles adds role creates, updates, and the hierarchy reorder to the
+// plan and returns the deletes for manageable live roles that are not in
+// config, leaving out the bot's own roles and any above them. Matched roles
+// at or above the bot's highest role are left as they are, with a warning,
+// since the bot can't edit or move those.
+func diffRoles(p *Plan, cfg *config.Config, live *Live) []*Change {
+	matches, matched := matchRoles(p, cfg, live)
+	blocked := checkHierarchy(p, cfg, live, matches)
+
+	for _, roleCfg := range cfg.Roles.Roles {
+		params := roleParams(roleCfg)
+
+		existing, ok := matches[roleCfg.StateKey()]
+		if !ok {
+			p.add(&Change{
+				Action: ActionCreate,
+				Kind:   KindRole,
+				Name:   roleCfg.Name,
+				Fields: []Field{
+					{Name: "color", New: formatColor(*params.Color)},
+					{Name: "permissions", New: formatPermissions(*params.Permissions)},
+					{Name: "hoist", New: fmt.Sprint(roleCfg.Hoist)},
+					{Name: "mentionable", New: fmt.Sprint(roleCfg.Mentionable)},
+				},
+				apply: func(session *discordgo.Session) error {
+					role, err := session.GuildRoleCreate(p.guildID, params)
+					if err != nil {
+						return err
+					}
+					p.ids.roles[roleCfg.StateKey()] = role.ID
+					return nil
+				},
+			})
+			continue
+		}
+
+		p.ids.roles[roleCfg.StateKey()] = existing.ID
+		if blocked[roleCfg.StateKey()] {
+			continue
+		}
+
+		var fields []Field
+		if existing.Name != roleCfg.Name {
+			fields = append(fields, Field{Name: "name", Old: existing.Name, New: roleCfg.Name})
+		}
+		if existing.Color != *params.Color {
+			fields = append(fields, Field{Name: "color", Old: formatColor(existing.Color), New: formatColor(*params.Color)})
+		}
+		if existing.Permissions != *params.Permissions {
+			fields = append(fields, Field{Name: "permissions", Old: formatPermissions(existing.Permissions), New: formatPermissions(*params.Permissions)})
+		}
+		if existing.Hoist != roleCfg.Hoist {
+			fields = append(fields, Field{Name: "hoist", Old: fmt.Sprint(existing.Hoist), New: fmt.Sprint(roleCfg.Hoist)})
+		}
+		if existing.Mentionable != roleCfg.Mentionable {
+			fields = append(fields, Field{Name: "mentionable", Old: fmt.Sprint(existing.Mentionable), New: fmt.Sprint(roleCfg.Mentionable)})
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		roleID := existing.ID
+		p.add(&Change{
+			Action: ActionUpdate,
+			Kind:   KindRole,
+			Name:   roleCfg.Name,
+			Fields: fields,
+			apply: func(session *discordgo.Session) error {
+				_, err := session.GuildRoleEdit(p.guildID, roleID, params)
+				return err
+			},
+		})
+	}
+
+	if change := diffRoleOrder(p, cfg, matches, blocked); change != nil {
+		p.add(change)
+	}
+
+	var deletes []*Change
+	for _, role := range live.Roles {
+		if !manageable(role, live.GuildID) || matched[role.ID] || !deletable(role, live) {
+			continue
+		}
+
+		roleID := role.ID
+		deletes = append(deletes, &Change{
+			Action: ActionDelete,
+			Kind:   KindRole,
+			Name:   role.Name,
+			id:     roleID,
+			apply: func(session *discordgo.Session) error {
+				return session.GuildRoleDelete(p.guildID, roleID)
+			},
+		})
+	}
+
+	return deletes
+}
+
+// matchRoles pairs config roles (by state key) with live roles, first by
+// the ID recorded in state, then by name, then by previous names, and
+// returns the pairs along with the set of matched live role IDs. Matching by
+// ID first means a role renamed in Discord is renamed back, and a role
+// renamed in config is renamed in place, instead of being recreated.
+func matchRoles(p *Plan, cfg *config.Config, live *Live) (map[string]*discordgo.Role, map[string]bool) {
+	byID := make(map[string]*discordgo.Role)
+	for _, role := range live.Roles {
+		if manageable(role, live.GuildID) {
+			byID[role.ID] = role
+		}
+	}
+
+	matches := make(map[string]*discordgo.Role)
+	matched := make(map[string]bool)
+
+	for _, roleCfg := range cfg.Roles.Roles {
+		for _, id := range trackedIDs(p.state.Roles, roleCfg.StateKey(), roleCfg.PreviousNames) {
+			if role, ok := byID[id]; ok && !matched[role.ID] {
+				matches[roleCfg.StateKey()] = role
+				matched[role.ID] = true
+				break
+			}
+		}
+	}
+
+	for _, roleCfg := range cfg.Roles.Roles {
+		if _, ok := matches[roleCfg.StateKey()]; ok {
+			continue
+		}
+		for _, role := range findRoles(live, matched, names(roleCfg.Name, roleCfg.PreviousNames)) {
+			matches[roleCfg.StateKey()] = role
+			matched[role.ID] = true
+			break
+		}
+	}
+
+	return matches, matched
+}
+
+// checkHierarchy returns the state keys of the matched roles the bot can't
+// act on because they are at or above its own highest role, and warns about
+// them in the plan
+func checkHierarchy(p *Plan, cfg *config.Config, live *Live, matches map[string]*discordgo.Role) map[string]bool {
+	blocked := make(map[string]bool)
+	top := live.BotTopRole()
+	if top == nil {
+		return blocked
+	}
+
+	var names []string
+	for _, roleCfg := range cfg.Roles.Roles {
+		if existing, ok := matches[roleCfg.StateKey()]; ok && existing.Position >= top.Position {
+			blocked[roleCfg.StateKey()] = true
+			names = append(names, roleCfg.Name)
+		}
+	}
+
+	if len(names) > 0 {
+		p.warn("Roles at or above the bot's highest role %q are left as they are: %s (move %q above them in Server Settings → Roles)",
+			top.Name, strings.Join(names, ", "), top.Name)
+	}
+
+	return blocked
+}
+
+// rolePosition is one entry of the bulk role reorder payload. discordgo's
+// GuildRoleReorder sends whole Role objects; only ID and position are needed.
+type rolePosition struct {
+	ID       string `json:"id"`
+	Position int    `json:"position"`
+}
+
+// diffRoleOrder returns a single bulk reorder that arranges managed roles in
+// config order, highest first, or nil if the hierarchy already matches.
+// Managed roles are permuted among the positions they already occupy, so
+// unmanaged roles (including the bot's) keep their place. Blocked roles are
+// left out, since the bot can't move them.
+func diffRoleOrder(p *Plan, cfg *config.Config, matches map[string]*discordgo.Role, blocked map[string]bool) *Change {
+	var ordered []config.Role
+	for _, roleCfg := range sortedRoles(cfg.Roles.Roles) {
+		if !blocked[roleCfg.StateKey()] {
+			ordered = append(ordered, roleCfg)
+		}
+	}
+
+	var want []string
+	for _, roleCfg := range ordered {
+		want = append(want, roleCfg.Name)
+	}
+
+	var existing []*discordgo.Role
+	names := make(map[string]string)
+	for _, roleCfg := range ordered {
+		if role, ok := matches[roleCfg.StateKey()]; ok {
+			existing = append(existing, role)
+			names[role.ID] = roleCfg.Name
+		}
+	}
+	sortByHierarchy(existing)
+
+	var have []string
+	for _, role := range existing {
+		have = append(have, names[role.ID])
+	}
+
+	if slices.Equal(have, want) {
+		return nil
+	}
+
+	return &Change{
+		Action: ActionUpdate,
+		Kind:   KindOrder,
+		Name:   "roles",
+		Fields: []Field{{Name: "hierarchy", Old: strings.Join(have, ", "), New: strings.Join(want, ", ")}},
+		apply: func(session *discordgo.Session) error {
+			// Re-read positions, since roles created in this run start at the bottom
+			current, err := session.GuildRoles(p.guildID)
+			if err != nil {
+				return err
+			}
+			byID := make(map[string]*discordgo.Role)
+			for _, role := range current {
+				byID[role.ID] = role
+			}
+
+			var managed []*discordgo.Role
+			for _, roleCfg := range ordered {
+				if role, ok := byID[p.ids.roles[roleCfg.StateKey()]]; ok {
+					managed = append(managed, role)
+				}
+			}
+
+			slots := make([]*discordgo.Role, len(managed))
+			copy(slots, managed)
+			sortByHierarchy(slots)
+
+			positions := make([]rolePosition, len(managed))
+			for i, role := range managed {
+				positions[i] = rolePosition{ID: role.ID, Position: slots[i].Position}
+			}
+
+			endpoint := discordgo.EndpointGuildRoles(p.guildID)
+			_, err = session.RequestWithBucketID("PATCH", endpoint, positions, endpoint)
+			return err
+		},
+	}
+}
+
+// sortedRoles returns roles in hierarchy order, highest first. A role's
+// rank is its position if set, otherwise its place in the file.
+func sortedRoles(roles []config.Role) []config.Role {
+	rank := func(i int) int {
+		if roles[i].Position != 0 {
+			return roles[i].Position
+		}
+		return i + 1
+	}
+
+	indexes := make([]int, len(roles))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool { return rank(indexes[a]) < rank(indexes[b]) })
+
+	sorted := make([]config.Role, len(roles))
+	for i, index := range indexes {
+		sorted[i] = roles[index]
+	}
+
+	return sorted
+}
+
+// sortByHierarchy sorts live roles highest first, breaking position ties
+// the way Discord does, by ID
+func sortByHierarchy(roles []*discordgo.Role) {
+	sort.SliceStable(roles, func(i, j int) bool {
+		if roles[i].Position != roles[j].Position {
+			return roles[i].Position > roles[j].Position
+		}
+		return snowflake.Less(roles[i].ID, roles[j].ID)
+	})
+}
+
+// findRoles returns the unmatched manageable live roles with one of the
+// given names, in name order
+func findRoles(live *Live, matched map[string]bool, names []string) []*discordgo.Role {
+	var found []*discordgo.Role
+	for _, name := range names {
+		for _, role := range live.Roles {
+			if manageable(role, live.GuildID) && !matched[role.ID] && role.Name == name {
+				found = append(found, role)
+			}
+		}
+	}
+
+	return found
+}
+
+// manageable reports whether a live role can be reconciled. @everyone
+// (whose ID is the guild ID) and roles managed by integrations or bots
+// cannot be edited or deleted.
+func manageable(role *discordgo.Role, guildID string) bool {
+	return role.ID != guildID && !role.Managed
+}
+
+// deletable reports whether the bot can delete a live role without cutting
+// off its own permissions: the role is not one the bot holds, and is below
+// the bot's highest role, the same rule checkHierarchy applies
+func deletable(role *discordgo.Role, live *Live) bool {
+	if slices.Contains(live.BotRoleIDs, role.ID) {
+		return false
+	}
+
+	top := live.BotTopRole()
+	return top == nil || role.Position < top.Position
+}
+
+func roleParams(roleCfg config.Role) *discordgo.RoleParams {
+	color := parseColor(roleCfg.Color)
+	perms := permissions.Bits(roleCfg.Permissions)
+	hoist := roleCfg.Hoist
+	mentionable := roleCfg.Mentionable
+
+	return &discordgo.RoleParams{
+		Name:        roleCfg.Name,
+		Color:       &color,
+		Permissions: &perms,
+		Hoist:       &hoist,
+		Mentionable: &mentionable,
+	}
+}
+
+func parseColor(hex string) int {
+	var color int
+	fmt.Sscanf(hex, "#%x", &color)
+	return color
+}
+
+func formatColor(color int) string {
+	return fmt.Sprintf("#%06x", color)
+}
+
+// formatPermissions lists a bitfield's permissions by name, with any bits
+// that have no name shown in hex
+func formatPermissions(bits int64) string {
+	names, unknown := permissions.Names(bits)
+	if unknown != 0 {
+		names = append(names, fmt.Sprintf("unknown 0x%x", unknown))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,190 @@
+package reconcile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/bwmarrin/discordgo"
+)
+
+// diffServer returns a single guild edit for the server.yaml settings and
+// special channel assignments that differ from the live guild, or nil if
+// they match. Empty settings are left as they are in Discord.
+func diffServer(p *Plan, cfg *config.Config, live *Live) *Change {
+	guild := live.Guild
+	if guild == nil {
+		return nil
+	}
+
+	server := cfg.Server
+	var fields []Field
+	data := make(map[string]interface{})
+
+	if server.Name != "" && guild.Name != server.Name {
+		fields = append(fields, Field{Name: "name", Old: guild.Name, New: server.Name})
+		data["name"] = server.Name
+	}
+	if server.Description != "" && guild.Description != server.Description {
+		fields = append(fields, Field{Name: "description", Old: quote(guild.Description), New: quote(server.Description)})
+		data["description"] = server.Description
+	}
+
+	settings := []struct {
+		name  string
+		key   string
+		value string
+		names []string
+		have  int
+	}{
+		{"verification_level", "verification_level", server.Settings.VerificationLevel, config.VerificationLevels, int(guild.VerificationLevel)},
+		{"default_notification_level", "default_message_notifications", server.Settings.DefaultNotificationLevel, config.DefaultNotificationLevels, int(guild.DefaultMessageNotifications)},
+		{"explicit_content_filter", "explicit_content_filter", server.Settings.ExplicitContentFilter, config.ExplicitContentFilters, int(guild.ExplicitContentFilter)},
+	}
+	for _, setting := range settings {
+		if setting.value == "" {
+			continue
+		}
+		want := config.SettingValue(setting.names, setting.value)
+		if want != setting.have {
+			fields = append(fields, Field{Name: setting.name, Old: config.SettingName(setting.names, setting.have), New: setting.value})
+			data[setting.key] = want
+		}
+	}
+
+	features := []struct {
+		name    string
+		feature discordgo.GuildFeature
+		want    bool
+	}{
+		{"community", discordgo.GuildFeatureCommunity, server.Features.Community},
+		{"discoverable", discordgo.GuildFeatureDiscoverable, server.Features.Discoverable},
+	}
+	toggled := false
+	for _, f := range features {
+		if have := hasFeature(guild, f.feature); have != f.want {
+			fields = append(fields, Field{Name: f.name, Old: fmt.Sprint(have), New: fmt.Sprint(f.want)})
+			toggled = true
+		}
+	}
+	if toggled {
+		// Discord takes the full feature list and ignores features that
+		// can't be toggled, so start from the live list
+		list := []discordgo.GuildFeature{}
+		for _, feature := range guild.Features {
+			if feature != discordgo.GuildFeatureCommunity && feature != discordgo.GuildFeatureDiscoverable {
+				list = append(list, feature)
+			}
+		}
+		for _, f := range features {
+			if f.want {
+				list = append(list, f.feature)
+			}
+		}
+		data["features"] = list
+	}
+
+	// Channels are resolved again at apply time, since they may be created
+	// earlier in the same run
+	special := server.Channels
+	assignments := []struct {
+		name string
+		key  string
+		ref  string
+		have string
+	}{
+		{"system_channel", "system_channel_id", special.System, guild.SystemChannelID},
+		{"rules_channel", "rules_channel_id", special.Rules, guild.RulesChannelID},
+		{"public_updates_channel", "public_updates_channel_id", special.PublicUpdates, guild.PublicUpdatesChannelID},
+		{"afk_channel", "afk_channel_id", special.AFK, guild.AfkChannelID},
+	}
+	channels := make(map[string]string)
+	for _, a := range assignments {
+		if a.ref == "" {
+			continue
+		}
+		ch := cfg.Channels.FindChannel(a.ref)
+		if id, ok := p.ids.channels[ch.StateKey()]; !ok || id != a.have {
+			fields = append(fields, Field{Name: a.name, Old: channelLabel(live, a.have), New: "#" + ch.Name})
+			channels[a.key] = ch.StateKey()
+		}
+	}
+
+	if special.AFKTimeout != 0 && guild.AfkTimeout != special.AFKTimeout {
+		fields = append(fields, Field{Name: "afk_timeout", Old: fmt.Sprintf("%ds", guild.AfkTimeout), New: fmt.Sprintf("%ds", special.AFKTimeout)})
+		data["afk_timeout"] = special.AFKTimeout
+	}
+
+	if special.SystemChannelFlags != nil {
+		want := config.FlagBits(special.SystemChannelFlags)
+		if have := int(guild.SystemChannelFlags); have != want {
+			fields = append(fields, Field{Name: "system_channel_flags", Old: formatFlags(have), New: formatFlags(want)})
+			data["system_channel_flags"] = want
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return &Change{
+		Action: ActionUpdate,
+		Kind:   KindServer,
+		Name:   guild.Name,
+		Fields: fields,
+		apply: func(session *discordgo.Session) error {
+			for key, channel := range channels {
+				id, err := p.channelID(channel)
+				if err != nil {
+					return err
+				}
+				data[key] = id
+			}
+			return editGuild(session, p.guildID, data)
+		},
+	}
+}
+
+// channelLabel names a live channel for display, or "none" if unset
+func channelLabel(live *Live, id string) string {
+	if id == "" {
+		return "none"
+	}
+	if name := live.channelName(id); name != "" {
+		return "#" + name
+	}
+
+	return id
+}
+
+// formatFlags lists system channel flags by name, with any bits that have
+// no name shown in hex
+func formatFlags(bits int) string {
+	names, unknown := config.FlagNames(bits)
+	if unknown != 0 {
+		names = append(names, fmt.Sprintf("unknown 0x%x", unknown))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// editGuild sends a partial guild update. Like ChannelEdit, discordgo's
+// GuildEdit omits zero values, so "all_messages" or "disabled" could never
+// be set through it.
+func editGuild(session *discordgo.Session, guildID string, data map[string]interface{}) error {
+	_, err := session.RequestWithBucketID("PATCH", discordgo.EndpointGuild(guildID), data, discordgo.EndpointGuild(guildID))
+	return err
+}
+
+func hasFeature(guild *discordgo.Guild, feature discordgo.GuildFeature) bool {
+	for _, f := range guild.Features {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
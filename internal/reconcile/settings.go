@@ -0,0 +1,163 @@
+package reconcile
+
+import (
+	"fmt"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/bwmarrin/discordgo"
+)
+
+// diffSettings adds the message, thread, and voice settings of a matched
+// channel that differ from config to an update's fields and payload. Only
+// settings that apply to the channel's type are compared.
+func diffSettings(ch config.Channel, existing *discordgo.Channel, extra ChannelExtra, fields []Field, data map[string]interface{}) []Field {
+	if config.HasSlowmode(ch.Type) && existing.RateLimitPerUser != ch.Slowmode {
+		fields = append(fields, Field{Name: "slowmode", Old: formatSeconds(existing.RateLimitPerUser), New: formatSeconds(ch.Slowmode)})
+		data["rate_limit_per_user"] = ch.Slowmode
+	}
+
+	if existing.NSFW != ch.NSFW {
+		fields = append(fields, Field{Name: "nsfw", Old: fmt.Sprint(existing.NSFW), New: fmt.Sprint(ch.NSFW)})
+		data["nsfw"] = ch.NSFW
+	}
+
+	if config.HasThreads(ch.Type) && ch.DefaultAutoArchive != 0 && extra.DefaultAutoArchiveDuration != ch.DefaultAutoArchive {
+		fields = append(fields, Field{Name: "default_auto_archive", Old: formatMinutes(extra.DefaultAutoArchiveDuration), New: formatMinutes(ch.DefaultAutoArchive)})
+		data["default_auto_archive_duration"] = ch.DefaultAutoArchive
+	}
+
+	if config.HasThreadSlowmode(ch.Type) && existing.DefaultThreadRateLimitPerUser != ch.DefaultThreadSlowmode {
+		fields = append(fields, Field{Name: "default_thread_slowmode", Old: formatSeconds(existing.DefaultThreadRateLimitPerUser), New: formatSeconds(ch.DefaultThreadSlowmode)})
+		data["default_thread_rate_limit_per_user"] = ch.DefaultThreadSlowmode
+	}
+
+	if !config.IsVoice(ch.Type) {
+		return fields
+	}
+
+	if ch.Bitrate != 0 && existing.Bitrate != ch.Bitrate {
+		fields = append(fields, Field{Name: "bitrate", Old: fmt.Sprint(existing.Bitrate), New: fmt.Sprint(ch.Bitrate)})
+		data["bitrate"] = ch.Bitrate
+	}
+
+	if existing.UserLimit != ch.UserLimit {
+		fields = append(fields, Field{Name: "user_limit", Old: formatUserLimit(existing.UserLimit), New: formatUserLimit(ch.UserLimit)})
+		data["user_limit"] = ch.UserLimit
+	}
+
+	if have := regionName(extra.RTCRegion); have != ch.RTCRegion {
+		fields = append(fields, Field{Name: "rtc_region", Old: formatRegion(have), New: formatRegion(ch.RTCRegion)})
+		data["rtc_region"] = regionValue(ch.RTCRegion)
+	}
+
+	if ch.VideoQuality != "" {
+		want := config.SettingValue(config.VideoQualityModes, ch.VideoQuality)
+		have := extra.VideoQualityMode
+		if have == 0 {
+			have = 1 // never set, which Discord treats as auto
+		}
+		if have != want {
+			fields = append(fields, Field{Name: "video_quality", Old: config.SettingName(config.VideoQualityModes, have), New: ch.VideoQuality})
+			data["video_quality_mode"] = want
+		}
+	}
+
+	return fields
+}
+
+// createSettings fills in the settings GuildChannelCreateData supports and
+// returns the rest as a payload for a follow-up edit. Settings left at
+// Discord's defaults are omitted.
+func createSettings(ch config.Channel, create *discordgo.GuildChannelCreateData) map[string]interface{} {
+	if config.HasSlowmode(ch.Type) {
+		create.RateLimitPerUser = ch.Slowmode
+	}
+	create.NSFW = ch.NSFW
+
+	data := make(map[string]interface{})
+	if config.HasThreads(ch.Type) && ch.DefaultAutoArchive != 0 {
+		data["default_auto_archive_duration"] = ch.DefaultAutoArchive
+	}
+	if config.HasThreadSlowmode(ch.Type) && ch.DefaultThreadSlowmode != 0 {
+		data["default_thread_rate_limit_per_user"] = ch.DefaultThreadSlowmode
+	}
+
+	if config.IsVoice(ch.Type) {
+		create.Bitrate = ch.Bitrate
+		create.UserLimit = ch.UserLimit
+		if ch.RTCRegion != "" {
+			data["rtc_region"] = ch.RTCRegion
+		}
+		if ch.VideoQuality != "" {
+			data["video_quality_mode"] = config.SettingValue(config.VideoQualityModes, ch.VideoQuality)
+		}
+	}
+
+	return data
+}
+
+// settingFields returns plan fields for the non-default settings of a new
+// channel
+func settingFields(ch config.Channel) []Field {
+	var fields []Field
+	add := func(set bool, name, value string) {
+		if set {
+			fields = append(fields, Field{Name: name, New: value})
+		}
+	}
+
+	add(ch.Slowmode != 0, "slowmode", formatSeconds(ch.Slowmode))
+	add(ch.NSFW, "nsfw", "true")
+	add(ch.DefaultAutoArchive != 0, "default_auto_archive", formatMinutes(ch.DefaultAutoArchive))
+	add(ch.DefaultThreadSlowmode != 0, "default_thread_slowmode", formatSeconds(ch.DefaultThreadSlowmode))
+	add(ch.Bitrate != 0, "bitrate", fmt.Sprint(ch.Bitrate))
+	add(ch.UserLimit != 0, "user_limit", formatUserLimit(ch.UserLimit))
+	add(ch.RTCRegion != "", "rtc_region", ch.RTCRegion)
+	add(ch.VideoQuality != "", "video_quality", ch.VideoQuality)
+
+	return fields
+}
+
+// regionName returns a live RTC region, "" meaning automatic
+func regionName(region *string) string {
+	if region == nil {
+		return ""
+	}
+	return *region
+}
+
+// regionValue returns the payload for an RTC region; null means automatic
+func regionValue(region string) interface{} {
+	if region == "" {
+		return nil
+	}
+	return region
+}
+
+func formatRegion(region string) string {
+	if region == "" {
+		return "automatic"
+	}
+	return region
+}
+
+func formatSeconds(seconds int) string {
+	if seconds == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+func formatMinutes(minutes int) string {
+	if minutes == 0 {
+		return "not set"
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+func formatUserLimit(limit int) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(limit)
+}
@@ -0,0 +1,107 @@
+package reconcile
+
+import (
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/state"
+	"github.com/bwmarrin/discordgo"
+)
+
+// githubWebhookName is the name of the webhook created for the GitHub
+// integration, and GitHubWebhookKey is its key in the state file. A webhook
+// is matched by the ID recorded in state, falling back to its name.
+const (
+	githubWebhookName = "GitHub"
+	GitHubWebhookKey  = "github"
+)
+
+// diffWebhooks adds integration webhook creates and updates to the plan and
+// returns deletes for integration webhooks that have been disabled. Webhooks
+// with other names are left alone.
+func diffWebhooks(p *Plan, cfg *config.Config, live *Live) []*Change {
+	existing := GitHubWebhook(live, p.state)
+
+	github := cfg.Integrations.GitHub
+	if github == nil || !github.Enabled {
+		if existing == nil {
+			return nil
+		}
+
+		webhookID := existing.ID
+		return []*Change{{
+			Action: ActionDelete,
+			Kind:   KindWebhook,
+			Name:   githubWebhookName,
+			apply: func(session *discordgo.Session) error {
+				return session.WebhookDelete(webhookID)
+			},
+		}}
+	}
+
+	// Config validation guarantees the target channel exists
+	target := github.TargetChannel
+	targetKey := cfg.Channels.FindChannel(target).StateKey()
+
+	if existing == nil {
+		p.add(&Change{
+			Action: ActionCreate,
+			Kind:   KindWebhook,
+			Name:   githubWebhookName,
+			Fields: []Field{{Name: "channel", New: target}},
+			apply: func(session *discordgo.Session) error {
+				channelID, err := p.channelID(targetKey)
+				if err != nil {
+					return err
+				}
+				webhook, err := session.WebhookCreate(channelID, githubWebhookName, "")
+				if err != nil {
+					return err
+				}
+				p.ids.webhooks[GitHubWebhookKey] = webhook.ID
+				p.webhooks = append(p.webhooks, webhook)
+				return nil
+			},
+		})
+		return nil
+	}
+
+	p.ids.webhooks[GitHubWebhookKey] = existing.ID
+
+	if channelID, ok := p.ids.channels[targetKey]; ok && channelID == existing.ChannelID {
+		return nil
+	}
+
+	webhookID := existing.ID
+	p.add(&Change{
+		Action: ActionUpdate,
+		Kind:   KindWebhook,
+		Name:   githubWebhookName,
+		Fields: []Field{{Name: "channel", Old: live.channelName(existing.ChannelID), New: target}},
+		apply: func(session *discordgo.Session) error {
+			channelID, err := p.channelID(targetKey)
+			if err != nil {
+				return err
+			}
+			_, err = session.WebhookEdit(webhookID, githubWebhookName, "", channelID)
+			return err
+		},
+	})
+
+	return nil
+}
+
+// GitHubWebhook returns the live webhook of the GitHub integration, matched
+// by the ID recorded in st, then by name, or nil if there is none
+func GitHubWebhook(live *Live, st *state.State) *discordgo.Webhook {
+	for _, wh := range live.Webhooks {
+		if wh.ID == st.Webhooks[GitHubWebhookKey] {
+			return wh
+		}
+	}
+	for _, wh := range live.Webhooks {
+		if wh.Type == discordgo.WebhookTypeIncoming && wh.Name == githubWebhookName {
+			return wh
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/Work-Fort/Discord/internal/archive"
+)
+
+// mention matches Discord's markup for user, role, and channel mentions and
+// custom emoji, and bare links
+var mention = regexp.MustCompile(`<(@!?|@&|#)(\d+)>|<a?:(\w+):\d+>|https?://[^\s<>]+`)
+
+// content renders message text in the site's format, replacing mentions
+// with names. Channel mentions link to the channel's page when it has one.
+func (s *site) content(text string, mentions []archive.User) string {
+	users := make(map[string]string)
+	for _, u := range mentions {
+		users[u.ID] = u.Name()
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range mention.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(s.text(text[last:m[0]]))
+		last = m[1]
+
+		match := text[m[0]:m[1]]
+		switch {
+		case m[2] >= 0:
+			kind, id := text[m[2]:m[3]], text[m[4]:m[5]]
+			b.WriteString(s.mention(kind, id, users))
+		case m[6] >= 0:
+			b.WriteString(s.text(":" + text[m[6]:m[7]] + ":"))
+		default:
+			b.WriteString(s.link(match, match))
+		}
+	}
+	b.WriteString(s.text(text[last:]))
+
+	return b.String()
+}
+
+// mention renders one mention by kind: "@" or "@!" for users, "@&" for
+// roles, "#" for channels
+func (s *site) mention(kind, id string, users map[string]string) string {
+	switch kind {
+	case "@&":
+		if name, ok := s.index.Roles[id]; ok {
+			return s.text("@" + name)
+		}
+		return s.text("@deleted-role")
+	case "#":
+		ch, ok := s.index.Channels[id]
+		if !ok {
+			return s.text("#unknown-channel")
+		}
+		if file, ok := s.files[id]; ok {
+			return s.link("#"+ch.Name, file)
+		}
+		return s.text("#" + ch.Name)
+	default:
+		if name, ok := users[id]; ok {
+			return s.text("@" + name)
+		}
+		return s.text("@unknown-user")
+	}
+}
+
+// text renders plain text. Markdown passes through, since Discord messages
+// are already Markdown, as does text for the plain format.
+func (s *site) text(text string) string {
+	if s.format == FormatHTML {
+		return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>\n")
+	}
+	return text
+}
+
+func (s *site) link(name, url string) string {
+	switch s.format {
+	case FormatHTML:
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(name))
+	case FormatMarkdown:
+		if name == url {
+			return "<" + url + ">"
+		}
+		return fmt.Sprintf("[%s](%s)", name, url)
+	default:
+		return name
+	}
+}
+
+// write renders one of the format's templates to path
+func (s *site) write(path, name string, data interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var execute func(io.Writer, string, interface{}) error
+	if s.format == FormatHTML {
+		execute = htmlTemplates.ExecuteTemplate
+	} else {
+		execute = markdownTemplates.ExecuteTemplate
+	}
+	if err := execute(f, name, data); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+var htmlTemplates = htmltemplate.Must(htmltemplate.New("").Funcs(htmltemplate.FuncMap{
+	// Content is escaped as it's rendered
+	"safe": func(s string) htmltemplate.HTML { return htmltemplate.HTML(s) },
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; color: #222; }
+nav { color: #666; margin-bottom: 1em; }
+.message { border-top: 1px solid #eee; padding: 0.5em 0; }
+.message:target { background: #fff8d0; }
+.meta { color: #666; font-size: 0.9em; }
+.author { font-weight: bold; color: #222; }
+.reply { color: #666; font-size: 0.9em; border-left: 3px solid #ccc; padding-left: 0.5em; }
+.embed { border-left: 3px solid #5865f2; padding: 0.25em 0.75em; margin: 0.5em 0; background: #f6f6f8; }
+img { max-width: 100%; max-height: 30em; display: block; margin: 0.5em 0; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "index"}}{{template "head" "Message archive"}}<h1>Message archive</h1>
+{{range .}}<h2>{{if .Category}}{{.Category}}{{else}}No category{{end}}</h2>
+<ul>
+{{range .Channels}}<li><a href="{{.File}}">#{{.Name}}</a></li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+{{end}}
+
+{{define "page"}}{{template "head" .Title}}<nav><a href="{{.Index}}">All channels</a>{{if .Parent}} › <a href="{{.Parent.File}}">#{{.Parent.Name}}</a>{{end}}</nav>
+<h1>{{if not .Thread}}#{{end}}{{.Title}}</h1>
+{{if .Threads}}<h2>Threads</h2>
+<ul>
+{{range .Threads}}<li><a href="{{.File}}">{{.Name}}</a></li>
+{{end}}</ul>
+{{end}}{{range .Messages}}<div class="message" id="m{{.ID}}">
+{{if .Reply}}<div class="reply">↪ <a href="#m{{.Reply.ID}}">{{if .Reply.Author}}{{.Reply.Author}}: {{end}}{{.Reply.Excerpt}}</a></div>
+{{end}}<div class="meta"><span class="author">{{.Author}}</span>{{if .Bot}} (bot){{end}} · <a href="#m{{.ID}}">{{.Time}}</a></div>
+{{if .Content}}<div class="content">{{safe .Content}}</div>
+{{end}}{{range .Images}}<a href="{{.File}}"><img src="{{.File}}" alt="{{.Name}}"></a>
+{{end}}{{range .Attachments}}<div>📎 <a href="{{.File}}">{{.Name}}</a></div>
+{{end}}{{range .Embeds}}<div class="embed">
+{{if .Author}}<div class="meta">{{.Author}}</div>
+{{end}}{{if .Title}}<div><strong>{{if .URL}}<a href="{{.URL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</strong></div>
+{{end}}{{if .Description}}<div>{{safe .Description}}</div>
+{{end}}{{if .Image}}<img src="{{.Image}}" alt="">
+{{end}}</div>
+{{end}}{{if .Reactions}}<div class="meta">{{.Reactions}}</div>
+{{end}}{{if .Thread}}<div class="meta">🧵 Thread: <a href="{{.Thread.File}}">{{.Thread.Name}}</a></div>
+{{end}}</div>
+{{end}}</body>
+</html>
+{{end}}
+`))
+
+var markdownTemplates = texttemplate.Must(texttemplate.New("").Funcs(texttemplate.FuncMap{
+	// Continues a blockquote over every line
+	"quote": func(s string) string { return strings.ReplaceAll(s, "\n", "\n> ") },
+}).Parse(`
+{{define "index"}}# Message archive
+{{range .}}
+## {{if .Category}}{{.Category}}{{else}}No category{{end}}
+
+{{range .Channels}}- [#{{.Name}}]({{.File}})
+{{end}}{{end}}{{end}}
+
+{{define "page"}}[All channels]({{.Index}}){{if .Parent}} › [#{{.Parent.Name}}]({{.Parent.File}}){{end}}
+
+# {{if not .Thread}}#{{end}}{{.Title}}
+{{if .Threads}}
+## Threads
+
+{{range .Threads}}- [{{.Name}}]({{.File}})
+{{end}}{{end}}{{range .Messages}}
+---
+
+<a id="m{{.ID}}"></a>
+{{if .Reply}}> ↪ [{{if .Reply.Author}}{{.Reply.Author}}: {{end}}{{.Reply.Excerpt}}](#m{{.Reply.ID}})
+
+{{end}}**{{.Author}}**{{if .Bot}} (bot){{end}} · [{{.Time}}](#m{{.ID}})
+{{if .Content}}
+{{.Content}}
+{{end}}{{range .Images}}
+![{{.Name}}]({{.File}})
+{{end}}{{range .Attachments}}
+📎 [{{.Name}}]({{.File}})
+{{end}}{{range .Embeds}}
+>{{if .Author}} *{{.Author}}*{{end}}{{if .Title}} **{{if .URL}}[{{.Title}}]({{.URL}}){{else}}{{.Title}}{{end}}**{{end}}
+{{if .Description}}>
+> {{quote .Description}}
+{{end}}{{if .Image}}>
+> ![]({{.Image}})
+{{end}}{{end}}{{if .Reactions}}
+{{.Reactions}}
+{{end}}{{if .Thread}}
+🧵 Thread: [{{.Thread.Name}}]({{.Thread.File}})
+{{end}}{{end}}{{end}}
+`))
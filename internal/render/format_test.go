@@ -0,0 +1,60 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/Work-Fort/Discord/internal/archive"
+)
+
+func testSite(format string) *site {
+	index := archive.NewIndex("1")
+	index.Roles["10"] = "Mods"
+	index.Channels["20"] = &archive.Channel{Name: "general", Type: "text"}
+	index.Channels["21"] = &archive.Channel{Name: "off-topic", Type: "text"}
+	return &site{format: format, index: index, files: map[string]string{"20": "general.html"}}
+}
+
+func TestContentHTML(t *testing.T) {
+	s := testSite(FormatHTML)
+	mentions := []archive.User{{ID: "30", Username: "kit", DisplayName: "Kit <3"}}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"<b>bold</b> & co", "&lt;b&gt;bold&lt;/b&gt; &amp; co"},
+		{"one\ntwo", "one<br>\ntwo"},
+		{"hi <@30>", "hi @Kit &lt;3"},
+		{"hi <@!31>", "hi @unknown-user"},
+		{"<@&10> <@&11>", "@Mods @deleted-role"},
+		{"see <#20>", `see <a href="general.html">#general</a>`},
+		{"see <#21> <#22>", "see #off-topic #unknown-channel"},
+		{"<:party:123>", ":party:"},
+		{`https://example.com/?a=1&b="2"`, `<a href="https://example.com/?a=1&amp;b=&#34;2&#34;">https://example.com/?a=1&amp;b=&#34;2&#34;</a>`},
+	}
+	for _, tt := range tests {
+		if got := s.content(tt.text, mentions); got != tt.want {
+			t.Errorf("content(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestContentMarkdown(t *testing.T) {
+	s := testSite(FormatMarkdown)
+	s.files["20"] = "general.md"
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"**bold** <b>\nnext", "**bold** <b>\nnext"},
+		{"hi <@30>", "hi @unknown-user"},
+		{"see <#20>", "see [#general](general.md)"},
+		{"https://example.com", "<https://example.com>"},
+	}
+	for _, tt := range tests {
+		if got := s.content(tt.text, nil); got != tt.want {
+			t.Errorf("content(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Work-Fort/Discord/internal/archive"
+	"github.com/Work-Fort/Discord/internal/snowflake"
+)
+
+// Output formats
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+
+	// formatPlain renders text with mentions resolved but no links, for
+	// previews that are escaped by the page's template
+	formatPlain = ""
+)
+
+// site is an archive being rendered: the pages to write, by channel ID
+type site struct {
+	format string
+	dir    string // the archive
+	out    string
+	index  *archive.Index
+	files  map[string]string
+}
+
+// page is one channel or thread, ready for a format's template
+type page struct {
+	Title    string
+	Thread   bool
+	Parent   *link // the channel a thread is in
+	Messages []message
+	Threads  []link
+	Index    string // file name of the site's index page
+}
+
+type link struct {
+	Name string
+	File string
+}
+
+type message struct {
+	ID          string
+	Author      string
+	Bot         bool
+	Time        string
+	Reply       *reply
+	Content     string // in the page's format
+	Images      []link
+	Attachments []link
+	Embeds      []embed
+	Reactions   string
+	Thread      *link
+}
+
+type reply struct {
+	ID      string
+	Author  string
+	Excerpt string
+}
+
+type embed struct {
+	Title       string
+	URL         string
+	Author      string
+	Description string // in the page's format
+	Image       string
+}
+
+// Run renders the message archive in dir as a static site of HTML or
+// Markdown pages in out: an index, and a page per channel and per thread,
+// with the archive's saved images copied into out/images.
+// If channels is non-empty, only the channels with those names and their
+// threads are rendered.
+func Run(dir, out, format string, channels []string) error {
+	if format != FormatHTML && format != FormatMarkdown {
+		return fmt.Errorf("unknown format %q (must be %s or %s)", format, FormatHTML, FormatMarkdown)
+	}
+
+	index, err := archive.LoadIndex(dir, "")
+	if err != nil {
+		return err
+	}
+	if len(index.Channels) == 0 {
+		return fmt.Errorf("nothing archived in %s (run backup --messages first)", dir)
+	}
+
+	s := &site{format: format, dir: dir, out: out, index: index, files: make(map[string]string)}
+
+	selected, err := s.selectChannels(channels)
+	if err != nil {
+		return err
+	}
+
+	// Name every page first, so pages can link to any other
+	taken := make(map[string]bool)
+	for _, id := range selected {
+		s.files[id] = s.fileName(slug(index.Channels[id].Name), id, taken)
+		for _, thread := range s.threads(id) {
+			s.files[thread] = s.fileName(slug(index.Channels[id].Name)+"-"+slug(index.Channels[thread].Name), thread, taken)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(out, imagesDir), 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", out, err)
+	}
+
+	threads := 0
+	for _, id := range selected {
+		if err := s.writePage(id); err != nil {
+			return err
+		}
+		for _, thread := range s.threads(id) {
+			if err := s.writePage(thread); err != nil {
+				return err
+			}
+			threads++
+		}
+	}
+
+	if err := s.writeIndex(selected); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Rendered %d channels and %d threads to %s\n", len(selected), threads, out)
+
+	return nil
+}
+
+// selectChannels returns the IDs of the channels to render, by category and
+// then name. Threads are rendered with their channel.
+func (s *site) selectChannels(names []string) ([]string, error) {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var ids []string
+	found := make(map[string]bool)
+	for id, ch := range s.index.Channels {
+		if ch.ParentID != "" {
+			continue
+		}
+		if len(wanted) == 0 || wanted[ch.Name] {
+			ids = append(ids, id)
+			found[ch.Name] = true
+		}
+	}
+
+	for _, name := range names {
+		if !found[name] {
+			return nil, fmt.Errorf("channel %q isn't in the archive", name)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := s.index.Channels[ids[i]], s.index.Channels[ids[j]]
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		return a.Name < b.Name
+	})
+
+	return ids, nil
+}
+
+// threads returns the IDs of a channel's archived threads, oldest first
+func (s *site) threads(channelID string) []string {
+	var ids []string
+	for id, ch := range s.index.Channels {
+		if ch.ParentID == channelID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return snowflake.Less(ids[i], ids[j]) })
+
+	return ids
+}
+
+// fileName returns a page's file name, made from its name, falling back to
+// adding the channel ID if another page has it
+func (s *site) fileName(name, id string, taken map[string]bool) string {
+	file := name + s.ext()
+	if taken[file] {
+		file = name + "-" + id + s.ext()
+	}
+	taken[file] = true
+
+	return file
+}
+
+func (s *site) ext() string {
+	if s.format == FormatHTML {
+		return ".html"
+	}
+	return ".md"
+}
+
+// writePage renders one channel or thread
+func (s *site) writePage(id string) error {
+	ch := s.index.Channels[id]
+	messages, err := archive.Messages(s.dir, id)
+	if err != nil {
+		return err
+	}
+
+	p := page{
+		Title:  ch.Name,
+		Thread: ch.ParentID != "",
+		Index:  "index" + s.ext(),
+	}
+	if parent, ok := s.index.Channels[ch.ParentID]; ok {
+		p.Parent = &link{Name: parent.Name, File: s.files[ch.ParentID]}
+	}
+	for _, thread := range s.threads(id) {
+		p.Threads = append(p.Threads, link{Name: s.index.Channels[thread].Name, File: s.files[thread]})
+	}
+
+	byID := make(map[string]archive.Message)
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+	for _, m := range messages {
+		msg, err := s.message(m, byID)
+		if err != nil {
+			return err
+		}
+		p.Messages = append(p.Messages, msg)
+	}
+
+	return s.write(filepath.Join(s.out, s.files[id]), "page", p)
+}
+
+// message prepares a message for a template
+func (s *site) message(m archive.Message, byID map[string]archive.Message) (message, error) {
+	out := message{
+		ID:      m.ID,
+		Author:  m.Author.Name(),
+		Bot:     m.Author.Bot,
+		Time:    m.Timestamp.UTC().Format("2006-01-02 15:04 UTC"),
+		Content: s.content(m.Content, m.Mentions),
+	}
+
+	if m.ReplyTo != "" {
+		out.Reply = &reply{ID: m.ReplyTo, Excerpt: "a message that wasn't archived"}
+		if target, ok := byID[m.ReplyTo]; ok {
+			out.Reply.Author = target.Author.Name()
+			plain := &site{format: formatPlain, index: s.index}
+			out.Reply.Excerpt = excerpt(plain.content(target.Content, target.Mentions))
+		}
+	}
+
+	for _, a := range m.Attachments {
+		if a.IsImage() {
+			src, err := s.image(a.File, a.URL)
+			if err != nil {
+				return out, err
+			}
+			out.Images = append(out.Images, link{Name: a.Filename, File: src})
+		} else {
+			out.Attachments = append(out.Attachments, link{Name: fmt.Sprintf("%s (%s)", a.Filename, size(a.Size)), File: a.URL})
+		}
+	}
+
+	for _, e := range m.Embeds {
+		image, err := s.image(e.ImageFile, e.Image)
+		if err != nil {
+			return out, err
+		}
+		out.Embeds = append(out.Embeds, embed{
+			Title:       e.Title,
+			URL:         e.URL,
+			Author:      e.Author,
+			Description: s.content(e.Description, nil),
+			Image:       image,
+		})
+	}
+
+	var reactions []string
+	for _, r := range m.Reactions {
+		reactions = append(reactions, fmt.Sprintf("%s %d", r.Emoji, r.Count))
+	}
+	out.Reactions = strings.Join(reactions, " · ")
+
+	if file, ok := s.files[m.ThreadID]; ok {
+		out.Thread = &link{Name: s.index.Channels[m.ThreadID].Name, File: file}
+	}
+
+	return out, nil
+}
+
+// imagesDir is where a site's images are copied, within its directory
+const imagesDir = "images"
+
+// image copies an image saved in the archive into the site and returns its
+// path there. An image the archive has no copy of, such as one that
+// couldn't be downloaded, is linked by its URL, though Discord's URLs
+// expire.
+func (s *site) image(file, rawURL string) (string, error) {
+	if file == "" {
+		return rawURL, nil
+	}
+
+	src := archive.FilePath(s.dir, file)
+	dest := filepath.Join(s.out, imagesDir, file)
+	if _, err := os.Stat(dest); err != nil {
+		data, err := os.ReadFile(src)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("  ⚠ %s is missing from the archive, linking its URL\n", src)
+			return rawURL, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", src, err)
+		}
+		if err := os.WriteFile(dest, data, 0644); err != nil {
+			return "", fmt.Errorf("writing %s: %w", dest, err)
+		}
+	}
+
+	// Pages are at the top of the site, and links use forward slashes
+	return imagesDir + "/" + file, nil
+}
+
+// writeIndex renders the index page, listing the channels by category
+func (s *site) writeIndex(selected []string) error {
+	type section struct {
+		Category string
+		Channels []link
+	}
+
+	var sections []section
+	for _, id := range selected {
+		ch := s.index.Channels[id]
+		if len(sections) == 0 || sections[len(sections)-1].Category != ch.Category {
+			sections = append(sections, section{Category: ch.Category})
+		}
+		last := &sections[len(sections)-1]
+		name := ch.Name
+		switch n := len(s.threads(id)); n {
+		case 0:
+		case 1:
+			name = ch.Name + " (1 thread)"
+		default:
+			name = fmt.Sprintf("%s (%d threads)", ch.Name, n)
+		}
+		last.Channels = append(last.Channels, link{Name: name, File: s.files[id]})
+	}
+
+	return s.write(filepath.Join(s.out, "index"+s.ext()), "index", sections)
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug turns a channel or thread name into a file name
+func slug(name string) string {
+	s := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if s == "" {
+		return "channel"
+	}
+	return s
+}
+
+// excerpt shortens a message for a reply preview
+func excerpt(content string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if runes := []rune(content); len(runes) > 80 {
+		return string(runes[:80]) + "…"
+	}
+	if content == "" {
+		return "(no text)"
+	}
+	return content
+}
+
+func size(bytes int) string {
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+}
@@ -0,0 +1,150 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/Work-Fort/Discord/internal/state"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Kinds are the resource kinds a restore can be limited to. Webhooks aren't
+// among them: backups don't include integrations.
+var Kinds = []reconcile.Kind{
+	reconcile.KindServer,
+	reconcile.KindRole,
+	reconcile.KindCategory,
+	reconcile.KindChannel,
+	reconcile.KindOverwrite,
+	reconcile.KindOrder,
+}
+
+// ParseKinds reads a comma-separated list of kinds. An empty list selects
+// every kind.
+func ParseKinds(list string) (map[reconcile.Kind]bool, error) {
+	kinds := make(map[reconcile.Kind]bool)
+	if list == "" {
+		for _, kind := range Kinds {
+			kinds[kind] = true
+		}
+		return kinds, nil
+	}
+
+	for _, name := range strings.Split(list, ",") {
+		kind := reconcile.Kind(strings.TrimSpace(name))
+		known := false
+		for _, k := range Kinds {
+			known = known || k == kind
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown kind %q (must be one of %s)", kind, kindNames())
+		}
+		kinds[kind] = true
+	}
+
+	return kinds, nil
+}
+
+func kindNames() string {
+	var names []string
+	for _, kind := range Kinds {
+		names = append(names, string(kind))
+	}
+	return strings.Join(names, ", ")
+}
+
+// Run makes the guild match a backup loaded into cfg, like sync does for
+// config/: missing resources are recreated and drifted ones reset, limited
+// to the selected kinds. Resources are matched by the IDs in the backup's
+// state file, then by name, so it also works on a new guild, and recorded
+// in config/state.yaml once applied. In a dry run, it only prints the
+// changes.
+func Run(cfg *config.Config, backupDir string, kinds map[reconcile.Kind]bool, dryRun bool) error {
+	session, err := discordgo.New("Bot " + cfg.BotToken)
+	if err != nil {
+		return fmt.Errorf("creating Discord session: %w", err)
+	}
+
+	if err := session.Open(); err != nil {
+		return fmt.Errorf("opening Discord connection: %w", err)
+	}
+	defer session.Close()
+
+	fmt.Println("Connected to Discord")
+	fmt.Printf("Restoring from %s...\n", backupDir)
+
+	live, err := reconcile.Fetch(session, cfg.GuildID)
+	if err != nil {
+		return fmt.Errorf("reading server state: %w", err)
+	}
+
+	st, err := state.Load(filepath.Join(backupDir, "state.yaml"), cfg.GuildID)
+	if errors.Is(err, state.ErrOtherGuild) {
+		fmt.Println("  ⊙ Backup is from another server, matching resources by name")
+		st = state.New(cfg.GuildID)
+	} else if err != nil {
+		return fmt.Errorf("loading backup state: %w", err)
+	}
+
+	plan, err := reconcile.Build(cfg, live, st)
+	if err != nil {
+		return fmt.Errorf("building plan: %w", err)
+	}
+	plan.PrintWarnings(os.Stdout)
+
+	// New channels need their categories, so restoring channels creates
+	// missing categories too
+	plan.Only(func(c *reconcile.Change) bool {
+		return kinds[c.Kind] || c.Kind == reconcile.KindCategory && c.Action == reconcile.ActionCreate && kinds[reconcile.KindChannel]
+	})
+
+	if plan.Empty() {
+		fmt.Println("  ⊙ No changes, server matches backup")
+		plan.PrintPrune(os.Stdout)
+		return nil
+	}
+
+	if dryRun {
+		plan.Print(os.Stdout)
+		fmt.Println()
+		fmt.Printf("Plan: %s\n", plan.Summary())
+		return nil
+	}
+
+	fmt.Printf("  Applying %s\n", plan.Summary())
+	err = plan.Apply(session)
+
+	// In report mode, unmanaged resources are listed but left in place
+	if plan.PruneMode == config.PruneReport {
+		plan.PrintPrune(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+
+	return saveState(cfg.GuildID, plan.State())
+}
+
+// saveState records the restored resources' IDs in config/state.yaml, so
+// sync tracks them. Entries the backup doesn't cover, such as webhooks,
+// are kept.
+func saveState(guildID string, restored *state.State) error {
+	st, err := state.Load(state.Path, guildID)
+	if errors.Is(err, state.ErrOtherGuild) {
+		st = state.New(guildID)
+	} else if err != nil {
+		return fmt.Errorf("loading state: %w", err)
+	}
+
+	st.Merge(restored)
+	if err := st.Save(state.Path); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+
+	return nil
+}
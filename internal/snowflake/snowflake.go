@@ -0,0 +1,11 @@
+package snowflake
+
+// Less orders Discord IDs (snowflakes) by creation time, which is how
+// Discord breaks ties between equal positions. IDs are decimal numbers, so
+// a shorter ID is older.
+func Less(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
@@ -0,0 +1,129 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Path is where the state file lives, alongside the config it tracks
+var Path = filepath.Join("config", "state.yaml")
+
+const header = `# WorkFort Discord State
+# Maps config entries to Discord IDs so resources are tracked by identity,
+# not by their current name. Written by setup and sync; do not edit by hand.
+
+`
+
+// ErrOtherGuild is returned by Load for a state file recorded for a
+// different guild
+var ErrOtherGuild = errors.New("state file belongs to another guild")
+
+// State records which Discord ID belongs to each role, category, channel,
+// forum tag, and webhook in config. Keys are config names; forum tags are
+// keyed "<channel>/<tag>".
+type State struct {
+	GuildID    string            `yaml:"guild_id"`
+	Roles      map[string]string `yaml:"roles,omitempty"`
+	Categories map[string]string `yaml:"categories,omitempty"`
+	Channels   map[string]string `yaml:"channels,omitempty"`
+	Tags       map[string]string `yaml:"tags,omitempty"`
+	Webhooks   map[string]string `yaml:"webhooks,omitempty"`
+}
+
+// New returns an empty state for a guild
+func New(guildID string) *State {
+	return &State{
+		GuildID:    guildID,
+		Roles:      make(map[string]string),
+		Categories: make(map[string]string),
+		Channels:   make(map[string]string),
+		Tags:       make(map[string]string),
+		Webhooks:   make(map[string]string),
+	}
+}
+
+// Load reads the state file at path. A missing file yields an empty state,
+// since nothing has been tracked yet. A state file recorded for a different
+// guild is an error rather than being silently reused.
+func Load(path, guildID string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(guildID), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	st := New(guildID)
+	if err := yaml.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	if st.GuildID != guildID {
+		return nil, fmt.Errorf("%w: %s is for guild %s, not %s", ErrOtherGuild, path, st.GuildID, guildID)
+	}
+
+	// Sections omitted from the file unmarshal as nil maps
+	if st.Roles == nil {
+		st.Roles = make(map[string]string)
+	}
+	if st.Categories == nil {
+		st.Categories = make(map[string]string)
+	}
+	if st.Channels == nil {
+		st.Channels = make(map[string]string)
+	}
+	if st.Tags == nil {
+		st.Tags = make(map[string]string)
+	}
+	if st.Webhooks == nil {
+		st.Webhooks = make(map[string]string)
+	}
+
+	return st, nil
+}
+
+// Save writes the state file to path. Map keys are sorted by the encoder,
+// so the output is stable across runs.
+func (s *State) Save(path string) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshaling state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating state directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, append([]byte(header), data...), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// IDs returns every Discord ID tracked by the state
+func (s *State) IDs() map[string]bool {
+	ids := make(map[string]bool)
+	for _, section := range []map[string]string{s.Roles, s.Categories, s.Channels, s.Tags, s.Webhooks} {
+		for _, id := range section {
+			ids[id] = true
+		}
+	}
+
+	return ids
+}
+
+// Merge records other's IDs over s's, keeping entries other doesn't have
+func (s *State) Merge(other *State) {
+	maps.Copy(s.Roles, other.Roles)
+	maps.Copy(s.Categories, other.Categories)
+	maps.Copy(s.Channels, other.Channels)
+	maps.Copy(s.Tags, other.Tags)
+	maps.Copy(s.Webhooks, other.Webhooks)
+}
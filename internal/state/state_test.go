@@ -0,0 +1,88 @@
+package state
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "state.yaml")
+
+	st := New("1")
+	st.Roles["Admin"] = "11"
+	st.Categories["COMMUNITY"] = "100"
+	st.Channels["general"] = "200"
+	st.Webhooks["github"] = "500"
+	if err := st.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(loaded.IDs(), st.IDs()) || loaded.Roles["Admin"] != "11" || loaded.Channels["general"] != "200" {
+		t.Errorf("loaded state differs from the saved one: %+v", loaded)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	st, err := Load(filepath.Join(t.TempDir(), "state.yaml"), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sections are usable without nil checks
+	st.Roles["Admin"] = "11"
+	if st.GuildID != "1" || len(st.IDs()) != 1 {
+		t.Errorf("missing state file didn't load as empty: %+v", st)
+	}
+}
+
+func TestLoadPartial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.yaml")
+	if err := os.WriteFile(path, []byte("guild_id: \"1\"\nroles:\n  Admin: \"11\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := Load(path, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Channels["general"] = "200"
+	if st.Roles["Admin"] != "11" {
+		t.Errorf("roles didn't load: %+v", st)
+	}
+}
+
+func TestLoadOtherGuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.yaml")
+	if err := New("2").Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(path, "1")
+	if !errors.Is(err, ErrOtherGuild) || !strings.Contains(err.Error(), "guild 2") {
+		t.Errorf("got error %v, want one naming the other guild", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	st := New("1")
+	st.Roles["Admin"] = "11"
+	st.Webhooks["github"] = "500"
+
+	other := New("1")
+	other.Roles["Admin"] = "12"
+	other.Channels["general"] = "200"
+
+	st.Merge(other)
+	want := map[string]bool{"12": true, "200": true, "500": true}
+	if !maps.Equal(st.IDs(), want) {
+		t.Errorf("merged IDs %v, want %v", st.IDs(), want)
+	}
+}
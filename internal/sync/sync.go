@@ -2,8 +2,11 @@ package sync
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/Work-Fort/Discord/internal/config"
+	"github.com/Work-Fort/Discord/internal/reconcile"
+	"github.com/Work-Fort/Discord/internal/state"
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -23,14 +26,41 @@ func Run(cfg *config.Config) error {
 	fmt.Println("Connected to Discord")
 	fmt.Println("Syncing configuration...")
 
-	// For now, sync is a simplified implementation
-	// Full implementation would:
-	// 1. Fetch existing server state
-	// 2. Compare with desired config
-	// 3. Apply only the differences (update/create/delete)
+	// Fetch existing server state and compare with desired config
+	live, err := reconcile.Fetch(session, cfg.GuildID)
+	if err != nil {
+		return fmt.Errorf("reading server state: %w", err)
+	}
+
+	st, err := state.Load(state.Path, cfg.GuildID)
+	if err != nil {
+		return fmt.Errorf("loading state: %w", err)
+	}
+
+	plan, err := reconcile.Build(cfg, live, st)
+	if err != nil {
+		return fmt.Errorf("building plan: %w", err)
+	}
+	plan.PrintWarnings(os.Stdout)
+
+	if plan.Empty() {
+		fmt.Println("  ⊙ No changes, server matches config")
+	} else {
+		fmt.Printf("  Applying %s\n", plan.Summary())
+
+		// Apply only the differences
+		err = plan.Apply(session)
+	}
 
-	fmt.Println("  Note: Full sync implementation pending")
-	fmt.Println("  For now, use 'setup' command or manually update via Discord")
+	// In report mode, unmanaged resources are listed but left in place
+	if plan.PruneMode == config.PruneReport {
+		plan.PrintPrune(os.Stdout)
+	}
+
+	// Record IDs even after a partial apply so created resources stay tracked
+	if err := plan.State().Save(state.Path); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
 
-	return nil
+	return err
 }